@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/abennett/ttt/pkg/messages"
+)
+
+var pastPhaseStyle = lipgloss.NewStyle().
+	Faint(true)
+
+var currentPhaseStyle = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("#01c5d1")).
+	Bold(true)
+
+// morePhases reports whether the agenda has phases still to come.
+func (t *ttt) morePhases() bool {
+	return t.room.Phase < len(t.room.Agenda)-1
+}
+
+// changePhase asks to move step phases along the agenda. Only the host can.
+func (t *ttt) changePhase(step int) (bool, error) {
+	if len(t.room.Agenda) == 0 || !t.isHost() {
+		return false, nil
+	}
+	idx := t.room.Phase + step
+	if idx < 0 || idx >= len(t.room.Agenda) {
+		return true, nil
+	}
+	return true, t.client.SetPhase(idx)
+}
+
+// renderAgenda lists the phases, dimming those done and highlighting the
+// current one.
+func renderAgenda(room messages.RoomState) string {
+	if len(room.Agenda) == 0 {
+		return ""
+	}
+	parts := make([]string, len(room.Agenda))
+	for idx, phase := range room.Agenda {
+		switch {
+		case idx < room.Phase:
+			parts[idx] = pastPhaseStyle.Render("✓ " + phase.Name)
+		case idx == room.Phase:
+			parts[idx] = currentPhaseStyle.Render("▶ " + phase.Name)
+		default:
+			parts[idx] = phase.Name
+		}
+	}
+	return "Agenda: " + strings.Join(parts, " → ")
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/abennett/ttt/pkg/messages"
+)
+
+var errUnknownCommand = errors.New("unknown command, try /poll, /fist, /endpoll or /pick")
+
+// chatLines is how much of the room's chat history is shown.
+const chatLines = 6
+
+var (
+	chatStyle = lipgloss.NewStyle().
+			Width(48).
+			PaddingLeft(1)
+	chatUserStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("#01c5d1"))
+	chatTimeStyle = lipgloss.NewStyle().
+			Faint(true)
+)
+
+func newChatInput() textinput.Model {
+	input := textinput.New()
+	input.Prompt = "💬 "
+	input.Placeholder = "say something, enter to send"
+	input.CharLimit = 280
+	input.Width = 44
+	return input
+}
+
+func renderChat(chat []messages.ChatMessage) string {
+	if len(chat) > chatLines {
+		chat = chat[len(chat)-chatLines:]
+	}
+	lines := make([]string, len(chat))
+	for idx, msg := range chat {
+		lines[idx] = chatTimeStyle.Render(msg.At.Local().Format("15:04")) + " " +
+			chatUserStyle.Render(msg.User) + ": " + msg.Text
+	}
+	return chatStyle.Render(strings.Join(lines, "\n"))
+}
+
+// updateChat handles keys while the chat input has focus.
+func (t *ttt) updateChat(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		return t, t.quit()
+	case "esc":
+		t.chat.Blur()
+		t.chat.Reset()
+		return t, nil
+	case "enter":
+		text := strings.TrimSpace(t.chat.Value())
+		t.chat.Blur()
+		t.chat.Reset()
+		if text == "" {
+			return t, nil
+		}
+		if strings.HasPrefix(text, "/") {
+			if err := t.command(text); err != nil {
+				slog.Error("command failed", "command", text, "error", err)
+			}
+			return t, nil
+		}
+		if err := t.client.SendChat(text); err != nil {
+			slog.Error("chat failed", "error", err)
+		}
+		return t, nil
+	}
+	var cmd tea.Cmd
+	t.chat, cmd = t.chat.Update(msg)
+	return t, cmd
+}
+
+// command runs a slash command typed into the chat input:
+//
+//	/poll [--anon] question | option | option...
+//	/fist [--anon] question
+//	/endpoll
+//	/pick [n] [role]
+func (t *ttt) command(text string) error {
+	name, rest, _ := strings.Cut(text, " ")
+	rest = strings.TrimSpace(rest)
+	anonymous := false
+	if after, ok := strings.CutPrefix(rest, "--anon"); ok {
+		anonymous = true
+		rest = strings.TrimSpace(after)
+	}
+	switch name {
+	case "/poll":
+		parts := strings.Split(rest, "|")
+		return t.client.AskPoll(messages.PollRequest{
+			Question:  parts[0],
+			Options:   parts[1:],
+			Anonymous: anonymous,
+		})
+	case "/fist":
+		return t.client.AskPoll(messages.PollRequest{
+			Question:   rest,
+			FistOfFive: true,
+			Anonymous:  anonymous,
+		})
+	case "/endpoll":
+		return t.client.ClosePoll()
+	case "/pick":
+		count := 1
+		first, role, _ := strings.Cut(rest, " ")
+		if n, err := strconv.Atoi(first); err == nil {
+			count = n
+		} else {
+			role = rest
+		}
+		return t.client.Pick(count, strings.TrimSpace(role))
+	default:
+		return fmt.Errorf("%w: %s", errUnknownCommand, name)
+	}
+}
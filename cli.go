@@ -3,11 +3,19 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
+	"fmt"
 	"io"
 	"log/slog"
+	"os"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/table"
+	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 
@@ -21,30 +29,76 @@ var baseStyle = lipgloss.NewStyle().
 	BorderStyle(lipgloss.NormalBorder()).
 	Align(lipgloss.Center)
 
+var headerStyle = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("#01c5d1")).
+	Bold(true)
+
 var columns = []table.Column{
-	{Title: "User", Width: 10},
+	{Title: "User", Width: 16},
+	{Title: "Kudos", Width: 12},
 	{Title: "Result", Width: 6},
-	{Title: "Done", Width: 6},
+	{Title: "Roll", Width: 12},
+	{Title: "Status", Width: 6},
 }
 
 type ttt struct {
 	client *client.Client
 	table  table.Model
+	chat   textinput.Model
+	room   messages.RoomState
+	// rowUsers maps table rows to participants, with "" for team separators.
+	rowUsers []string
+	// reacting is set while picking a reaction for the selected row.
+	reacting bool
+	// voting is set while picking a planning poker card.
+	voting bool
+	// polling is set while the poll overlay has the keyboard.
+	polling    bool
+	pollCursor int
+	// pollID is the last poll seen, to spot when the host asks another.
+	pollID int
+	// notes is the standup notes form, open while editingNotes is set.
+	notes        []textinput.Model
+	notesFocus   int
+	editingNotes bool
+	// flashes counts down the animation frames left for recent reactions.
+	flashes map[string]int
+	// timing is set while the speaker's timebox is ticking.
+	timing bool
+	// err is why the session ended, if it was cut short.
+	err error
+}
+
+// tableKeys only keeps the table's line movement so the remaining keys are
+// free for room actions.
+func tableKeys() table.KeyMap {
+	km := table.DefaultKeyMap()
+	disabled := key.NewBinding(key.WithDisabled())
+	km.PageUp = disabled
+	km.PageDown = disabled
+	km.HalfPageUp = disabled
+	km.HalfPageDown = disabled
+	km.GotoTop = key.NewBinding(key.WithKeys("home"))
+	km.GotoBottom = key.NewBinding(key.WithKeys("end"))
+	return km
 }
 
 func newTTT(c *client.Client) (*ttt, error) {
 	t := table.New(
 		table.WithColumns(columns),
 		table.WithHeight(0),
-		table.WithFocused(false),
+		table.WithFocused(true),
+		table.WithKeyMap(tableKeys()),
 	)
 	s := table.DefaultStyles()
 	s.Header = s.Header.Foreground(lipgloss.Color("#01c5d1"))
-	s.Selected = s.Selected.Foreground(lipgloss.NoColor{}).Bold(false)
 	t.SetStyles(s)
 	return &ttt{
-		client: c,
-		table:  t,
+		client:  c,
+		table:   t,
+		chat:    newChatInput(),
+		notes:   newNotesInputs(),
+		flashes: make(map[string]int),
 	}, nil
 }
 
@@ -58,50 +112,362 @@ func (t *ttt) Init() tea.Cmd {
 	}
 }
 
+func statusIcon(s messages.Status) string {
+	switch s {
+	case messages.StatusDone:
+		return "✅"
+	case messages.StatusPassed:
+		return "⏭️"
+	case messages.StatusDeferred:
+		return "⏳"
+	case messages.StatusAbsent:
+		return "🚫"
+	case messages.StatusQueued:
+		return "🔜"
+	default:
+		return ""
+	}
+}
+
+// breakdown shows a result along with what settles ties on it: any
+// modifier and the roll-off.
+func breakdown(rr messages.RollResult) string {
+	var b strings.Builder
+	b.WriteString(strconv.Itoa(rr.Result))
+	if rr.Modifier != 0 {
+		fmt.Fprintf(&b, " (%+d)", rr.Modifier)
+	}
+	if rr.RollOff != 0 {
+		fmt.Fprintf(&b, " 🎲%d", rr.RollOff)
+	}
+	return b.String()
+}
+
 func resultsToRows(rrs []messages.RollResult) []table.Row {
 	rows := make([]table.Row, len(rrs))
 	for idx, rr := range rrs {
-		if rr.IsDone {
-			rows[idx] = table.Row{rr.User, strconv.Itoa(rr.Result), "✅"}
-		} else {
-			rows[idx] = table.Row{rr.User, strconv.Itoa(rr.Result), ""}
+		user := rr.User
+		if rr.Late {
+			user += " 🕒"
+		}
+		status := statusIcon(rr.Status)
+		if rr.Disconnected {
+			status = "📴" + status
 		}
+		rows[idx] = table.Row{user, kudos(rr), strconv.Itoa(rr.Result), breakdown(rr), status}
 	}
 	return rows
 }
 
+// notJoinedRows shows those on the roster who haven't joined, with no roll.
+func notJoinedRows(rrs []messages.RollResult) []table.Row {
+	rows := make([]table.Row, len(rrs))
+	for idx, rr := range rrs {
+		status := "💤"
+		if rr.Status == messages.StatusAbsent {
+			status = statusIcon(rr.Status)
+		}
+		rows[idx] = table.Row{rr.User, "", "", "not joined", status}
+	}
+	return rows
+}
+
+func teamSeparator(team string) table.Row {
+	if team == "" {
+		team = "no team"
+	}
+	return table.Row{"── " + team + " ──", "", "", "", ""}
+}
+
+// refreshRows redraws the table.
+func (t *ttt) refreshRows() {
+	rows, users := tableRows(t.room, t.flashes)
+	t.rowUsers = users
+	t.table.SetHeight(len(rows) + 1)
+	t.table.SetRows(rows)
+}
+
+// tableRows lays out the room's table, sparkling the kudos of anyone
+// flashing, marking who was picked, who has notes and whose notes are shown,
+// showing votes in place of results in poker mode and separating teams when
+// the room goes team by team. Anyone on the roster who hasn't joined is
+// listed last. Alongside the rows it returns who each row is for, with ""
+// for separators.
+func tableRows(room messages.RoomState, flashes map[string]int) ([]table.Row, []string) {
+	base := resultsToRows(room.Rolls)
+	rows := make([]table.Row, 0, len(base))
+	users := make([]string, 0, len(base))
+	for idx, rr := range room.Rolls {
+		if room.Teams != "" && (idx == 0 || rr.Team != room.Rolls[idx-1].Team) {
+			rows = append(rows, teamSeparator(rr.Team))
+			users = append(users, "")
+		}
+		row := base[idx]
+		if room.Mode == "poker" {
+			row[2] = voteCell(room, rr)
+		}
+		if picked(room, rr.User) {
+			row[0] += " ⭐"
+		}
+		if rr.Notes != nil {
+			row[0] += " 📝"
+			if current, ok := speaker(room.Rolls); ok && current.User == rr.User {
+				row[0] += "🗣"
+			}
+		}
+		if frames, ok := flashes[rr.User]; ok {
+			row[1] = sparkles[frames%len(sparkles)] + row[1]
+		}
+		rows = append(rows, row)
+		users = append(users, rr.User)
+	}
+	if len(room.NotJoined) > 0 && room.Teams != "" {
+		rows = append(rows, table.Row{"── not joined ──", "", "", "", ""})
+		users = append(users, "")
+	}
+	rows = append(rows, notJoinedRows(room.NotJoined)...)
+	for _, rr := range room.NotJoined {
+		users = append(users, rr.User)
+	}
+	return rows, users
+}
+
+func roomHeader(room messages.RoomState) string {
+	name := room.Name
+	if room.Protected {
+		name += " 🔑"
+	}
+	if room.Locked {
+		name += " 🔒"
+	}
+	count := strconv.Itoa(len(room.Rolls))
+	if room.Capacity > 0 {
+		count += "/" + strconv.Itoa(room.Capacity)
+	}
+	parts := []string{name, count + " in room", room.Dice}
+	if room.ManualOrder {
+		parts = append(parts, "order: manual")
+	} else if room.Ordering != "" {
+		parts = append(parts, "order: "+room.Ordering)
+	}
+	if room.TieBreak != "" {
+		parts = append(parts, "ties: "+room.TieBreak)
+	}
+	if room.Mode == "poker" {
+		parts = append(parts, "🃏 poker")
+	}
+	if room.Teams != "" {
+		parts = append(parts, "teams: "+room.Teams)
+	}
+	if room.LateJoin != "" {
+		parts = append(parts, "late: "+room.LateJoin)
+	}
+	if room.JoinCode != "" {
+		parts = append(parts, "code: "+room.JoinCode)
+	}
+	if room.Spectators > 0 {
+		parts = append(parts, "👀 "+strconv.Itoa(room.Spectators))
+	}
+	if room.Timebox > 0 {
+		parts = append(parts, "⏱ "+clock(room.Timebox)+" each")
+	}
+	if !room.ClosesAt.IsZero() {
+		parts = append(parts, "📅 until "+room.ClosesAt.Local().Format("15:04"))
+	}
+	return headerStyle.Render(strings.Join(parts, " • "))
+}
+
+// ownStatus is the status of this client's user in the latest state.
+func (t *ttt) ownStatus() messages.Status {
+	for _, rr := range t.room.Rolls {
+		if rr.User == t.client.User() {
+			return rr.Status
+		}
+	}
+	return messages.StatusWaiting
+}
+
+// toggleStatus sets the user's status, or returns them to waiting if they
+// already have it.
+func (t *ttt) toggleStatus(status messages.Status) error {
+	if t.ownStatus() == status {
+		status = messages.StatusWaiting
+	}
+	return t.client.SetStatus("", status)
+}
+
+func (t *ttt) isHost() bool {
+	return t.room.Host == t.client.User()
+}
+
+// selected is the participant under the table cursor, who may not have
+// joined yet.
+func (t *ttt) selected() (messages.RollResult, bool) {
+	cursor := t.table.Cursor()
+	if cursor < 0 || cursor >= len(t.rowUsers) {
+		return messages.RollResult{}, false
+	}
+	for _, rr := range slices.Concat(t.room.Rolls, t.room.NotJoined) {
+		if rr.User == t.rowUsers[cursor] {
+			return rr, true
+		}
+	}
+	return messages.RollResult{}, false
+}
+
+// handleKey sends the request bound to k, reporting whether k is bound.
+// Spectators can only move around the table.
+func (t *ttt) handleKey(k string) (bool, error) {
+	if t.client.Spectator() {
+		return false, nil
+	}
+	if t.reacting {
+		return t.react(k)
+	}
+	if t.voting {
+		return t.pickCard(k)
+	}
+	switch k {
+	// Attempt to update done index
+	case " ":
+		return true, t.client.ToggleDone()
+	case "p":
+		return true, t.toggleStatus(messages.StatusPassed)
+	case "d":
+		return true, t.toggleStatus(messages.StatusDeferred)
+	case "L":
+		if !t.isHost() {
+			return true, nil
+		}
+		return true, t.client.SetLocked(!t.room.Locked)
+	case "r":
+		t.reacting = true
+		return true, nil
+	case "o":
+		t.polling = t.canVote()
+		return t.polling, nil
+	case "v":
+		t.voting = t.poker()
+		return t.voting, nil
+	case "V":
+		if !t.poker() || !t.isHost() {
+			return false, nil
+		}
+		return true, t.client.Reveal(!t.room.Revealed)
+	case "]":
+		return t.changePhase(1)
+	case "[":
+		return t.changePhase(-1)
+	}
+	return t.hostAction(k)
+}
+
+// hostAction handles the keys only the host can use on the selected row.
+// It reports whether the key was one of them.
+func (t *ttt) hostAction(k string) (bool, error) {
+	rr, ok := t.selected()
+	if !ok || !t.isHost() {
+		return false, nil
+	}
+	switch k {
+	case "K":
+		return true, t.client.MoveUser(rr.User, messages.MoveUp, 0)
+	case "J":
+		return true, t.client.MoveUser(rr.User, messages.MoveDown, 0)
+	case "T":
+		return true, t.client.MoveUser(rr.User, messages.MoveToTop, 0)
+	case "R":
+		return true, t.client.MoveUser(rr.User, messages.MoveReset, 0)
+	case "1", "2", "3", "4", "5", "6", "7", "8", "9":
+		return true, t.client.MoveUser(rr.User, messages.MoveToIndex, int(k[0]-'1'))
+	case "P":
+		return true, t.client.Pick(1, "")
+	case "a":
+		status := messages.StatusAbsent
+		if rr.Status == messages.StatusAbsent {
+			status = messages.StatusWaiting
+		}
+		return true, t.client.SetStatus(rr.User, status)
+	}
+	return false, nil
+}
+
 func (t *ttt) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
-	case []messages.RollResult:
-		slog.Debug("roll result")
-		t.table.SetHeight(len(msg) + 1)
-		t.table.SetRows(resultsToRows(msg))
-		for _, rr := range msg {
-			if !rr.IsDone {
-				return t, func() tea.Msg {
-					return t.client.ReadUpdate()
-				}
+	case messages.RoomState:
+		slog.Debug("room state")
+		selected, _ := t.selected()
+		flash := t.noteReactions(msg)
+		t.notePoll(msg)
+		t.room = msg
+		t.refreshRows()
+		// Keep the cursor on the same person as the order changes
+		for idx, user := range t.rowUsers {
+			if user != "" && user == selected.User {
+				t.table.SetCursor(idx)
+			}
+		}
+		keepReading := func() (tea.Model, tea.Cmd) {
+			return t, tea.Batch(flash, t.startTimer(), func() tea.Msg {
+				return t.client.ReadUpdate()
+			})
+		}
+		// Spectators, poker rooms and meetings with phases still to come
+		// carry on whoever is in the room, even no one
+		if t.client.Spectator() || t.poker() || t.morePhases() {
+			return keepReading()
+		}
+		// The round ends once everyone who joined has finished, without
+		// waiting for anyone on the roster who never turned up
+		for _, rr := range msg.Rolls {
+			if !rr.Status.IsFinished() {
+				return keepReading()
 			}
 		}
 		return t, tea.Quit
+	case flashMsg:
+		return t, t.updateFlash()
+	case timeboxTick:
+		return t, t.updateTimer()
 	case tea.KeyMsg:
+		if t.chat.Focused() {
+			return t.updateChat(msg)
+		}
+		if t.editingNotes {
+			return t.updateNotes(msg)
+		}
+		if t.polling {
+			return t.updatePoll(msg)
+		}
 		switch msg.String() {
 		case "ctrl+c", "q":
-			err := t.client.Close()
-			if err != nil {
-				slog.Error("failed to close client", "error", err)
+			return t, t.quit()
+		case "enter":
+			if !t.client.Spectator() {
+				return t, t.chat.Focus()
 			}
-			return t, tea.Quit
-
-		// Attempt to update done index
-		case " ":
-			err := t.client.ToggleDone()
-			if err != nil {
-				panic(err)
+		case "n":
+			if !t.client.Spectator() {
+				return t, t.editNotes()
 			}
 		}
+		handled, err := t.handleKey(msg.String())
+		if err != nil {
+			// A dropped connection is redialled by the client, so carry on
+			slog.Error("request failed", "key", msg.String(), "error", err)
+		}
+		if !handled {
+			var cmd tea.Cmd
+			t.table, cmd = t.table.Update(msg)
+			return t, cmd
+		}
+	case messages.ErrorMessage:
+		// The server turned us away or closed the room
+		t.err = errors.New(msg.Message)
+		return t, tea.Quit
 	case error:
 		slog.Error("exiting for error", "error", msg)
+		t.err = msg
 		return t, tea.Quit
 	default:
 		slog.Debug("unsupported message", "msg", msg)
@@ -110,21 +476,147 @@ func (t *ttt) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return t, nil
 }
 
+func (t *ttt) quit() tea.Cmd {
+	err := t.client.Close()
+	if err != nil {
+		slog.Error("failed to close client", "error", err)
+	}
+	return tea.Quit
+}
+
 func (t *ttt) View() string {
 	slog.Debug("rerendering view")
-	return baseStyle.Render(t.table.View()) + "\n"
+	var b strings.Builder
+	b.WriteString(roomHeader(t.room) + "\n")
+	if agenda := renderAgenda(t.room); agenda != "" {
+		b.WriteString(agenda + "\n")
+	}
+	b.WriteString(baseStyle.Render(t.table.View()) + "\n")
+	if timer := renderTimebox(t.room, time.Now()); timer != "" {
+		b.WriteString(timer + "\n")
+	}
+	if rr, ok := speaker(t.room.Rolls); ok && rr.Notes != nil {
+		b.WriteString(renderNotes(rr) + "\n")
+	}
+	if t.room.Pick != nil {
+		b.WriteString(renderPick(*t.room.Pick) + "\n")
+	}
+	if t.room.Estimate != nil {
+		b.WriteString(renderEstimate(*t.room.Estimate) + "\n")
+	}
+	if t.reacting {
+		b.WriteString(reactionPrompt() + "\n")
+	}
+	if t.voting {
+		b.WriteString(cardPrompt(t.room.Deck) + "\n")
+	}
+	if p := t.room.Poll; p != nil {
+		if t.polling {
+			b.WriteString(renderPollOverlay(*p, t.pollCursor) + "\n")
+		} else {
+			b.WriteString(renderPollResults(*p) + "\n")
+		}
+	}
+	if len(t.room.Chat) > 0 {
+		b.WriteString(renderChat(t.room.Chat) + "\n")
+	}
+	if t.chat.Focused() {
+		b.WriteString(t.chat.View() + "\n")
+	}
+	if t.editingNotes {
+		b.WriteString(t.notesForm() + "\n")
+	}
+	if blockers := renderBlockers(t.room.Rolls); blockers != "" {
+		b.WriteString(blockers + "\n")
+	}
+	return b.String()
+}
+
+func watchRemote(_ context.Context, args []string) error {
+	if len(args) != 2 {
+		return flag.ErrHelp
+	}
+	c, err := client.New(args[0], args[1], "", io.Discard,
+		client.AsSpectator(),
+		client.WithPassword(*watchPassword),
+	)
+	if err != nil {
+		return err
+	}
+	return runTUI(c)
+}
+
+// expectedRoster reads the roster for a new room from --roster and
+// --roster-file. Blank lines and lines starting with # are skipped.
+func expectedRoster() ([]string, error) {
+	var users []string
+	if *roster != "" {
+		users = strings.Split(*roster, ",")
+	}
+	if *rosterFile != "" {
+		b, err := os.ReadFile(*rosterFile)
+		if err != nil {
+			return nil, err
+		}
+		for line := range strings.Lines(string(b)) {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") {
+				users = append(users, line)
+			}
+		}
+	}
+	return users, nil
 }
 
 func rollRemote(_ context.Context, args []string) error {
-	c, err := client.New(args[0], args[1], args[2], io.Discard)
+	if len(args) != 3 {
+		return flag.ErrHelp
+	}
+	users, err := expectedRoster()
+	if err != nil {
+		return err
+	}
+	var phases []messages.Phase
+	if *agenda != "" {
+		if phases, err = messages.ParseAgenda(*agenda); err != nil {
+			return err
+		}
+	}
+	c, err := client.New(args[0], args[1], args[2], io.Discard,
+		client.WithModifier(*modifier),
+		client.WithPassword(*password),
+		client.WithTeam(*team),
+		client.WithRoomOptions(messages.RoomOptions{
+			Template: *template,
+			Dice:     *dice,
+			Timebox:  *timebox,
+			Ordering: *ordering,
+			TieBreak: *tieBreak,
+			LateJoin: *lateJoin,
+			Teams:    *teams,
+			Mode:     *mode,
+			Deck:     *deck,
+			Agenda:   phases,
+			Roster:   users,
+			Capacity: *capacity,
+			JoinCode: *joinCode,
+		}),
+	)
 	if err != nil {
 		return err
 	}
+	return runTUI(c)
+}
+
+func runTUI(c *client.Client) error {
 	ttt, err := newTTT(c)
 	if err != nil {
 		return err
 	}
 
 	_, err = tea.NewProgram(ttt).Run()
-	return err
+	if err != nil {
+		return err
+	}
+	return ttt.err
 }
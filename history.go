@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+
+	"github.com/abennett/ttt/pkg/client"
+	"github.com/abennett/ttt/pkg/messages"
+)
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+var historyHeaderStyle = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("#01c5d1")).
+	Bold(true).
+	Padding(0, 1)
+
+var historyCellStyle = lipgloss.NewStyle().
+	Padding(0, 1)
+
+// sparkline draws values as bars scaled between lo and hi.
+func sparkline(values []float64, lo, hi float64) string {
+	line := make([]rune, len(values))
+	for i, v := range values {
+		idx := 0
+		if hi > lo {
+			idx = int((v - lo) / (hi - lo) * float64(len(sparks)-1))
+		}
+		line[i] = sparks[max(0, min(idx, len(sparks)-1))]
+	}
+	return string(line)
+}
+
+// attended counts the sessions a user actually turned up to.
+func attended(entries []messages.HistoryEntry) []messages.HistoryEntry {
+	return slices.DeleteFunc(slices.Clone(entries), func(e messages.HistoryEntry) bool {
+		return e.Status == messages.StatusAbsent
+	})
+}
+
+func historyRow(user messages.UserHistory, sessions int) []string {
+	present := attended(user.Entries)
+	row := []string{
+		user.User,
+		fmt.Sprintf("%d/%d", len(present), sessions),
+		"-", "-", "-", "-", "-", "",
+	}
+	if len(present) == 0 {
+		return row
+	}
+	var rolls, positions, places []float64
+	var first, last, finished int
+	var toDone time.Duration
+	for _, e := range present {
+		rolls = append(rolls, float64(e.Result))
+		positions = append(positions, float64(e.Position))
+		// Scaled to the size of the session and flipped, so going first is
+		// always the tallest bar.
+		place := 1.0
+		if e.Of > 1 {
+			place = float64(e.Of-e.Position) / float64(e.Of-1)
+		}
+		places = append(places, place)
+		switch e.Position {
+		case 1:
+			first++
+		case e.Of:
+			last++
+		}
+		if e.TimeToDone > 0 {
+			toDone += e.TimeToDone
+			finished++
+		}
+	}
+	row[2] = strconv.FormatFloat(mean(rolls), 'f', 1, 64)
+	row[3] = strconv.FormatFloat(mean(positions), 'f', 1, 64)
+	row[4] = strconv.Itoa(first)
+	row[5] = strconv.Itoa(last)
+	if finished > 0 {
+		row[6] = (toDone / time.Duration(finished)).Round(time.Second).String()
+	}
+	row[7] = sparkline(places, 0, 1)
+	return row
+}
+
+func mean(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+func renderHistory(history messages.RoomHistory) string {
+	t := table.New().
+		Border(lipgloss.NormalBorder()).
+		Headers("User", "Attended", "Avg roll", "Avg place", "First", "Last", "Time to done", "Places").
+		StyleFunc(func(row, col int) lipgloss.Style {
+			if row == table.HeaderRow {
+				return historyHeaderStyle
+			}
+			return historyCellStyle
+		})
+	for _, user := range history.Users {
+		t.Row(historyRow(user, history.Sessions)...)
+	}
+	return fmt.Sprintf("%s: %d sessions\n%s", history.Room, history.Sessions, t.Render())
+}
+
+func showHistory(_ context.Context, args []string) error {
+	if len(args) != 2 {
+		return flag.ErrHelp
+	}
+	history, err := client.FetchHistory(args[0], args[1], *historyPassword)
+	if err != nil {
+		return err
+	}
+	if history.Sessions == 0 {
+		fmt.Printf("no sessions recorded for %s\n", history.Room)
+		return nil
+	}
+	fmt.Println(renderHistory(history))
+	return nil
+}
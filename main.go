@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -18,10 +19,52 @@ import (
 )
 
 var (
-	serverFS = flag.NewFlagSet("ttt", flag.ExitOnError)
-	port     = serverFS.Int("port", 8080, "port number of server")
+	serverFS   = flag.NewFlagSet("ttt", flag.ExitOnError)
+	port       = serverFS.Int("port", 8080, "port number of server")
+	grace      = serverFS.Duration("grace", server.DefaultGracePeriod, "how long a dropped participant's place is held for them to reconnect")
+	duplicates = serverFS.String("duplicates", "reject", "handling of a username already in the room: reject, suffix or share")
+	dataDir    = serverFS.String("data-dir", "", "directory to save rooms in so they survive a restart")
+	eventDir   = serverFS.String("event-log", "", "directory to write an event log for each room in, for ttt replay")
+	idle       = serverFS.Duration("idle", 0, "close rooms with no activity for this long, 0 to keep them")
+	roomTTL    = serverFS.Duration("ttl", 0, "close rooms this long after they were created, 0 for no limit")
+	schedule   = serverFS.String("schedule", "", "JSON file of rooms to open on a recurring schedule")
+	templates  = serverFS.String("templates", "", "JSON file of room templates")
+	adminToken = serverFS.String("admin-token", "", "token needed to change templates over HTTP, which is off without one")
 
-	clientFS = flag.NewFlagSet("ttt roll", flag.ExitOnError)
+	clientFS   = flag.NewFlagSet("ttt roll", flag.ExitOnError)
+	modifier   = clientFS.Int("modifier", 0, "initiative modifier, used to settle ties in rooms with the modifier tie-break")
+	dice       = clientFS.String("dice", "", "dice everyone rolls in a new room, such as 1d20 or 2d6+1")
+	template   = clientFS.String("template", "", "server template to create a new room from")
+	timebox    = clientFS.String("timebox", "", "how long each turn should take in a new room, such as 2m")
+	agenda     = clientFS.String("agenda", "", "phases for a new room, as name:mode:dice:timebox separated by commas")
+	ordering   = clientFS.String("order", "", "turn order for a new room: highest, lowest, join, alpha, shuffle or donelast")
+	tieBreak   = clientFS.String("tiebreak", "", "tie-break for a new room: join, rolloff, alpha or modifier")
+	password   = clientFS.String("password", "", "password or join code for the room; sets the password when creating it")
+	joinCode   = clientFS.Bool("join-code", false, "generate a join code for a new room")
+	capacity   = clientFS.Int("max", 0, "maximum number of participants in a new room, 0 for no limit")
+	lateJoin   = clientFS.String("late", "", "placement of late joiners in a new room: insert, append or queue")
+	team       = clientFS.String("team", "", "team to join as")
+	roster     = clientFS.String("roster", "", "comma separated list of who a new room expects")
+	rosterFile = clientFS.String("roster-file", "", "file listing who a new room expects, one name per line")
+	teams      = clientFS.String("teams", "", "go team by team in a new room, ordering teams by captain or average roll")
+	mode       = clientFS.String("mode", "", "what a new room is for: roll or poker")
+	deck       = clientFS.String("deck", "", "planning poker deck for a new room: fibonacci or tshirt")
+)
+
+var (
+	watchFS       = flag.NewFlagSet("ttt watch", flag.ExitOnError)
+	watchPassword = watchFS.String("password", "", "password or join code for the room")
+)
+
+var (
+	historyFS       = flag.NewFlagSet("ttt history", flag.ExitOnError)
+	historyPassword = historyFS.String("password", "", "password or join code the room had, to include its protected sessions")
+)
+
+var (
+	replayFS     = flag.NewFlagSet("ttt replay", flag.ExitOnError)
+	replaySpeed  = replayFS.Float64("speed", 1, "playback speed, such as 2 for twice as fast")
+	replayMaxGap = replayFS.Duration("max-gap", 5*time.Second, "longest pause between updates, 0 for no limit")
 )
 
 var (
@@ -37,6 +80,30 @@ var (
 		ShortUsage: "roll <host_with_protocol> <room> <username>",
 		Exec:       rollRemote,
 	}
+
+	watchCmd = &ffcli.Command{
+		Name:       "watch",
+		FlagSet:    watchFS,
+		ShortUsage: "watch <host_with_protocol> <room>",
+		ShortHelp:  "follow a room's turn order without taking part",
+		Exec:       watchRemote,
+	}
+
+	replayCmd = &ffcli.Command{
+		Name:       "replay",
+		FlagSet:    replayFS,
+		ShortUsage: "replay [flags] <logfile>",
+		ShortHelp:  "step through a room's event log",
+		Exec:       replayLog,
+	}
+
+	historyCmd = &ffcli.Command{
+		Name:       "history",
+		FlagSet:    historyFS,
+		ShortUsage: "history [flags] <host_with_protocol> <room>",
+		ShortHelp:  "show how each user has fared in a room's past sessions",
+		Exec:       showHistory,
+	}
 )
 
 func health(w http.ResponseWriter, r *http.Request) {
@@ -47,11 +114,54 @@ func serve(ctx context.Context, args []string) error {
 	h := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
 	slog.SetDefault(slog.New(h))
 
-	server := server.NewServer()
+	policy, err := server.ParseDuplicatePolicy(*duplicates)
+	if err != nil {
+		return err
+	}
+
+	opts := []server.Option{
+		server.WithDuplicatePolicy(policy),
+		server.WithGracePeriod(*grace),
+		server.WithIdleTimeout(*idle),
+		server.WithRoomTTL(*roomTTL),
+		server.WithAdminToken(*adminToken),
+	}
+	if *dataDir != "" {
+		store, err := server.NewFileStore(*dataDir)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, server.WithStore(store))
+	}
+	if *eventDir != "" {
+		opts = append(opts, server.WithEventLogs(*eventDir))
+	}
+	if *templates != "" {
+		list, err := server.LoadTemplates(*templates)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, server.WithTemplates(list...))
+	}
+	var schedules []server.Schedule
+	if *schedule != "" {
+		if schedules, err = server.LoadSchedules(*schedule); err != nil {
+			return err
+		}
+	}
+	server := server.NewServer(opts...)
+	if err := server.Restore(); err != nil {
+		return err
+	}
+	go server.RunJanitor(ctx)
+	go server.RunSchedules(ctx, schedules)
 	r := chi.NewRouter()
 	r.Use(middleware.DefaultLogger)
 	r.Get("/{roomName}", server.ServeHTTP)
+	r.Get("/{roomName}/history", server.ServeHistory)
 	r.Get("/health", health)
+	r.Get("/templates", server.ServeTemplates)
+	r.Put("/templates/{templateName}", server.ServeSaveTemplate)
 	port := ":" + strconv.Itoa(*port)
 	slog.Info("serving", "port", port)
 	return http.ListenAndServe(port, r)
@@ -80,6 +190,9 @@ func main() {
 			diceRollCmd,
 			serveCmd,
 			rollCmd,
+			watchCmd,
+			replayCmd,
+			historyCmd,
 		},
 		Exec: func(context.Context, []string) error {
 			return flag.ErrHelp
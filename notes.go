@@ -0,0 +1,143 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/abennett/ttt/pkg/messages"
+)
+
+var (
+	notesStyle = lipgloss.NewStyle().
+			BorderStyle(lipgloss.RoundedBorder()).
+			BorderForeground(lipgloss.Color("#01c5d1")).
+			Width(48).
+			Padding(0, 1)
+	notesLabelStyle = lipgloss.NewStyle().
+			Faint(true)
+	blockerStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("#e06c75"))
+)
+
+var noteLabels = []string{"Yesterday", "Today", "Blockers"}
+
+func newNotesInputs() []textinput.Model {
+	inputs := make([]textinput.Model, len(noteLabels))
+	for idx, label := range noteLabels {
+		input := textinput.New()
+		input.Prompt = label + ": "
+		input.CharLimit = 280
+		input.Width = 40
+		inputs[idx] = input
+	}
+	return inputs
+}
+
+// ownNotes is this client's user's notes in the latest state.
+func (t *ttt) ownNotes() messages.StandupNotes {
+	for _, rr := range t.room.Rolls {
+		if rr.User == t.client.User() && rr.Notes != nil {
+			return *rr.Notes
+		}
+	}
+	return messages.StandupNotes{}
+}
+
+// editNotes opens the notes form, filled in with what was sent before.
+func (t *ttt) editNotes() tea.Cmd {
+	notes := t.ownNotes()
+	for idx, value := range []string{notes.Yesterday, notes.Today, notes.Blockers} {
+		t.notes[idx].SetValue(value)
+		t.notes[idx].Blur()
+	}
+	t.notesFocus = 0
+	t.editingNotes = true
+	return t.notes[0].Focus()
+}
+
+// updateNotes handles keys while the notes form is open. Enter moves to the
+// next field and sends from the last one.
+func (t *ttt) updateNotes(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	next := t.notesFocus
+	switch msg.String() {
+	case "ctrl+c":
+		return t, t.quit()
+	case "esc":
+		t.editingNotes = false
+		return t, nil
+	case "tab", "down":
+		next = (t.notesFocus + 1) % len(t.notes)
+	case "shift+tab", "up":
+		next = (t.notesFocus + len(t.notes) - 1) % len(t.notes)
+	case "enter":
+		if t.notesFocus == len(t.notes)-1 {
+			t.editingNotes = false
+			err := t.client.SetNotes(messages.StandupNotes{
+				Yesterday: t.notes[0].Value(),
+				Today:     t.notes[1].Value(),
+				Blockers:  t.notes[2].Value(),
+			})
+			if err != nil {
+				slog.Error("notes failed", "error", err)
+			}
+			return t, nil
+		}
+		next = t.notesFocus + 1
+	default:
+		var cmd tea.Cmd
+		t.notes[t.notesFocus], cmd = t.notes[t.notesFocus].Update(msg)
+		return t, cmd
+	}
+	t.notes[t.notesFocus].Blur()
+	t.notesFocus = next
+	return t, t.notes[next].Focus()
+}
+
+func (t *ttt) notesForm() string {
+	lines := []string{headerStyle.Render("Standup notes")}
+	for _, input := range t.notes {
+		lines = append(lines, input.View())
+	}
+	lines = append(lines, notesLabelStyle.Render("tab to move, enter on the last line to send, esc to cancel"))
+	return notesStyle.Render(strings.Join(lines, "\n"))
+}
+
+// speaker is whoever's turn it is: the first participant still to go.
+func speaker(rolls []messages.RollResult) (messages.RollResult, bool) {
+	for _, rr := range rolls {
+		if !rr.Status.IsFinished() {
+			return rr, true
+		}
+	}
+	return messages.RollResult{}, false
+}
+
+// renderNotes expands the speaker's notes. They sit below the table, away from
+// the speaker's row, so the title says whose they are.
+func renderNotes(rr messages.RollResult) string {
+	lines := []string{headerStyle.Render("🗣 Notes from " + rr.User + ", speaking now")}
+	for idx, note := range []string{rr.Notes.Yesterday, rr.Notes.Today, rr.Notes.Blockers} {
+		if note != "" {
+			lines = append(lines, notesLabelStyle.Render(noteLabels[idx]+":")+" "+note)
+		}
+	}
+	return notesStyle.Render(strings.Join(lines, "\n"))
+}
+
+// renderBlockers gathers everyone's blockers into one list.
+func renderBlockers(rolls []messages.RollResult) string {
+	var lines []string
+	for _, rr := range rolls {
+		if rr.Notes != nil && rr.Notes.Blockers != "" {
+			lines = append(lines, "• "+chatUserStyle.Render(rr.User)+": "+rr.Notes.Blockers)
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return blockerStyle.Render("🚧 Blockers") + "\n" + strings.Join(lines, "\n")
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/abennett/ttt/pkg/messages"
+)
+
+// picked reports whether user was chosen by the room's latest pick.
+func picked(room messages.RoomState, user string) bool {
+	return room.Pick != nil && slices.Contains(room.Pick.Users, user)
+}
+
+func renderPick(p messages.Pick) string {
+	title := "⭐ Picked"
+	if p.Role != "" {
+		title += " for " + p.Role
+	}
+	return headerStyle.Render(title+": ") + strings.Join(p.Users, ", ")
+}
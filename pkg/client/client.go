@@ -5,8 +5,9 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"net/http"
 	"net/url"
-	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -16,11 +17,32 @@ import (
 	"github.com/abennett/ttt/pkg/messages"
 )
 
-var ErrTooManyRedirects = errors.New("too many redirects")
+var (
+	ErrTooManyRedirects = errors.New("too many redirects")
+	ErrRejected         = errors.New("server refused to join the room")
+	ErrConnectionLost   = errors.New("connection to the room was lost")
+)
+
+// reconnectAttempts is how many times a dropped connection is redialled,
+// waiting twice as long each time starting from reconnectDelay.
+const (
+	reconnectAttempts = 5
+	reconnectDelay    = 500 * time.Millisecond
+)
 
 type Client struct {
-	mu   *sync.Mutex
-	user string
+	mu          *sync.Mutex
+	user        string
+	modifier    int
+	team        string
+	password    string
+	roomOptions messages.RoomOptions
+
+	endpoint    string
+	header      http.Header
+	resumeToken string
+	closing     bool
+	spectator   bool
 
 	conn     *websocket.Conn
 	logger   *slog.Logger
@@ -29,16 +51,17 @@ type Client struct {
 	Room messages.RoomState
 }
 
-func connectLoop(wsUrl string) (*websocket.Conn, error) {
+func connectLoop(wsUrl string, header http.Header) (*websocket.Conn, error) {
 	for range 3 {
 		slog.Debug("attempting connection", "url", wsUrl)
-		conn, resp, err := websocket.DefaultDialer.Dial(wsUrl, nil)
+		conn, resp, err := websocket.DefaultDialer.Dial(wsUrl, header)
 		slog.Debug("connection attempted",
 			"resp", resp,
 			"error", err)
 		if err != nil {
-			if resp != nil {
-				_, _ = io.Copy(os.Stderr, resp.Body)
+			if resp != nil && resp.StatusCode >= 400 {
+				body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+				return nil, fmt.Errorf("%w: %s: %s", ErrRejected, resp.Status, strings.TrimSpace(string(body)))
 			}
 			return nil, err
 		}
@@ -54,7 +77,7 @@ func connectLoop(wsUrl string) (*websocket.Conn, error) {
 	return nil, ErrTooManyRedirects
 }
 
-func hostUrl(endpoint, room string) (string, error) {
+func hostUrl(endpoint, room string, query url.Values) (string, error) {
 	parsed, err := url.Parse(endpoint)
 	if err != nil {
 		return "", err
@@ -70,6 +93,7 @@ func hostUrl(endpoint, room string) (string, error) {
 	}
 	parsed.Scheme = scheme
 	parsed.Path = room
+	parsed.RawQuery = query.Encode()
 	return parsed.String(), nil
 }
 
@@ -81,73 +105,333 @@ func setupLogger(user string, logWriter io.Writer) *slog.Logger {
 	return logger
 }
 
-func New(host, room, user string, logWriter io.Writer) (*Client, error) {
-	logger := setupLogger(user, logWriter)
+// Option configures a Client before it connects.
+type Option func(*Client)
 
-	endpoint, err := hostUrl(host, room)
-	if err != nil {
-		return nil, err
+// WithRoomOptions sets the options used if this client creates the room.
+func WithRoomOptions(opts messages.RoomOptions) Option {
+	return func(c *Client) {
+		c.roomOptions = opts
 	}
-	slog.Debug("using endpoint", "endpoint", endpoint)
+}
 
-	conn, err := connectLoop(endpoint)
-	if err != nil {
-		return nil, err
+// WithPassword sets the password or join code used to enter the room. If
+// this client creates the room, it becomes the room's password.
+func WithPassword(password string) Option {
+	return func(c *Client) {
+		c.password = password
+	}
+}
+
+// AsSpectator makes the client watch the room without taking part in it.
+func AsSpectator() Option {
+	return func(c *Client) {
+		c.spectator = true
 	}
+}
+
+// WithModifier sets the user's initiative modifier, which settles ties in
+// rooms using the modifier tie-break. It never changes the roll itself.
+func WithModifier(modifier int) Option {
+	return func(c *Client) {
+		c.modifier = modifier
+	}
+}
 
-	return &Client{
+// WithResumeToken reclaims the place a resume token was handed out for,
+// such as when the client itself was restarted while its place was held.
+func WithResumeToken(token string) Option {
+	return func(c *Client) {
+		c.resumeToken = token
+	}
+}
+
+// WithTeam joins the user to a team, for rooms that go team by team.
+func WithTeam(team string) Option {
+	return func(c *Client) {
+		c.team = team
+	}
+}
+
+func New(host, room, user string, logWriter io.Writer, opts ...Option) (*Client, error) {
+	logger := setupLogger(user, logWriter)
+
+	c := &Client{
 		mu:       new(sync.Mutex),
 		user:     user,
 		logger:   logger,
-		conn:     conn,
 		messages: make(chan messages.Message, 1),
 		Room: messages.RoomState{
 			Rolls: []messages.RollResult{},
 		},
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	var err error
+	c.endpoint, err = hostUrl(host, room, c.roomOptions.Values())
+	if err != nil {
+		return nil, err
+	}
+	slog.Debug("using endpoint", "endpoint", c.endpoint)
+
+	c.header = http.Header{}
+	if c.password != "" {
+		c.header.Set(messages.PasswordHeader, c.password)
+	}
+	if c.spectator {
+		c.header.Set(messages.WatchHeader, "1")
+	}
+	header := c.header.Clone()
+	if c.resumeToken != "" {
+		header.Set(messages.ResumeHeader, c.resumeToken)
+	}
+	c.conn, err = connectLoop(c.endpoint, header)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
 }
 
 func (c *Client) Init() error {
 	c.logger.Debug("running Init")
-	req := messages.Message{
-		Type: messages.RollRequestType,
-		Payload: messages.RollResult{
-			User: c.user,
-		},
+	if err := c.join(); err != nil {
+		return err
 	}
-	b, err := msgpack.Marshal(req)
-	if err != nil {
-		return fmt.Errorf("failed to marshal: %w", err)
+	go c.updateLoop(c.messages)
+	return nil
+}
+
+// join sends the initial message that places the user in the room, or
+// puts them back in their old place if they hold a resume token. Spectators
+// just start watching again.
+func (c *Client) join() error {
+	if c.spectator {
+		c.logger.Debug("writing watch message")
+		if err := c.send(messages.WatchRequestType, messages.WatchRequest{Name: c.user}); err != nil {
+			return fmt.Errorf("unable to write server: %w", err)
+		}
+		return nil
+	}
+
+	c.mu.Lock()
+	req := messages.RollRequest{
+		User:        c.user,
+		Modifier:    c.modifier,
+		Team:        c.team,
+		ResumeToken: c.resumeToken,
 	}
+	c.mu.Unlock()
 
 	c.logger.Debug("writing initial message")
-	err = c.conn.WriteMessage(websocket.BinaryMessage, b)
-	if err != nil {
+	if err := c.send(messages.RollRequestType, req); err != nil {
 		return fmt.Errorf("unable to write server: %w", err)
 	}
-
-	go c.updateLoop(c.messages)
 	return nil
 }
 
-func (c *Client) ToggleDone() error {
-	doneReq := messages.DoneRequest{
-		User: c.user,
+// reconnect redials the room after the connection dropped and reclaims the
+// user's place with their resume token.
+func (c *Client) reconnect() error {
+	delay := reconnectDelay
+	for attempt := range reconnectAttempts {
+		time.Sleep(delay)
+		delay *= 2
+
+		c.logger.Info("reconnecting", "attempt", attempt+1)
+		header := c.header.Clone()
+		c.mu.Lock()
+		if c.resumeToken != "" {
+			header.Set(messages.ResumeHeader, c.resumeToken)
+		}
+		c.mu.Unlock()
+		conn, err := connectLoop(c.endpoint, header)
+		if err != nil {
+			c.logger.Error("reconnect failed", "error", err)
+			continue
+		}
+		c.mu.Lock()
+		c.conn = conn
+		c.mu.Unlock()
+		if err = c.join(); err != nil {
+			c.logger.Error("rejoin failed", "error", err)
+			continue
+		}
+		return nil
+	}
+	return ErrConnectionLost
+}
+
+// shouldReconnect reports whether a read error was an unexpected drop,
+// rather than the user leaving or the server turning them away.
+func (c *Client) shouldReconnect(err error) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closing || (c.resumeToken == "" && !c.spectator) {
+		return false
+	}
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		return closeErr.Code != websocket.CloseNormalClosure &&
+			closeErr.Code != websocket.ClosePolicyViolation
 	}
+	return true
+}
+
+// User is the name this client is known by in the room. The server may
+// have given it a different name to the one it asked for.
+func (c *Client) User() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.user
+}
+
+// State is the latest room state received from the server. Unlike reading
+// Room directly, it is safe while the client is running.
+func (c *Client) State() messages.RoomState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Room
+}
+
+// Spectator reports whether the client is only watching the room.
+func (c *Client) Spectator() bool {
+	return c.spectator
+}
+
+func (c *Client) send(t messages.Type, payload any) error {
 	m := messages.Message{
-		Type:    messages.DoneRequestType,
+		Type:    t,
 		Version: "1",
-		Payload: doneReq,
+		Payload: payload,
 	}
 	b, err := msgpack.Marshal(m)
 	if err != nil {
 		return err
 	}
-	err = c.conn.WriteMessage(websocket.BinaryMessage, b)
-	if err != nil {
-		return err
-	}
-	return nil
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.WriteMessage(websocket.BinaryMessage, b)
+}
+
+func (c *Client) ToggleDone() error {
+	return c.send(messages.DoneRequestType, messages.DoneRequest{
+		User: c.User(),
+	})
+}
+
+// SetStatus changes the status of target, or of this client's user when
+// target is empty. Changing anyone else's status requires being the host.
+func (c *Client) SetStatus(target string, status messages.Status) error {
+	return c.send(messages.StatusRequestType, messages.StatusRequest{
+		User:   c.User(),
+		Target: target,
+		Status: status,
+	})
+}
+
+// MoveUser asks the server to move target within the turn order. Only the
+// host may reorder the room.
+func (c *Client) MoveUser(target string, action messages.MoveAction, index int) error {
+	return c.send(messages.MoveUserRequestType, messages.MoveUserRequest{
+		User:   c.User(),
+		Target: target,
+		Action: action,
+		Index:  index,
+	})
+}
+
+// SetLocked asks the server to stop, or again allow, new users joining. Only
+// the host may lock the room.
+func (c *Client) SetLocked(locked bool) error {
+	return c.send(messages.LockRequestType, messages.LockRequest{
+		User:   c.User(),
+		Locked: locked,
+	})
+}
+
+// Vote picks card as this client's planning poker estimate. An empty card
+// takes the vote back.
+func (c *Client) Vote(card string) error {
+	return c.send(messages.VoteRequestType, messages.VoteRequest{
+		User: c.User(),
+		Card: card,
+	})
+}
+
+// Reveal turns the room's estimates over or, when reveal is false, clears
+// them for the next item. Only the host may reveal.
+func (c *Client) Reveal(reveal bool) error {
+	return c.send(messages.RevealRequestType, messages.RevealRequest{
+		User:   c.User(),
+		Reveal: reveal,
+	})
+}
+
+// AskPoll opens a poll in the room, replacing any already open. Only the
+// host may ask.
+func (c *Client) AskPoll(poll messages.PollRequest) error {
+	poll.User = c.User()
+	return c.send(messages.PollRequestType, poll)
+}
+
+// ClosePoll takes down the room's poll.
+func (c *Client) ClosePoll() error {
+	return c.AskPoll(messages.PollRequest{})
+}
+
+// VotePoll answers the open poll with the option at index option.
+func (c *Client) VotePoll(option int) error {
+	return c.send(messages.PollVoteType, messages.PollVote{
+		User:   c.User(),
+		Option: option,
+	})
+}
+
+// Pick asks the server to choose count present participants for role,
+// favouring those picked least before. Only the host may pick.
+func (c *Client) Pick(count int, role string) error {
+	return c.send(messages.PickRequestType, messages.PickRequest{
+		User:  c.User(),
+		Count: count,
+		Role:  role,
+	})
+}
+
+// SetNotes shares this client's standup notes with the room, replacing any
+// sent before.
+func (c *Client) SetNotes(notes messages.StandupNotes) error {
+	return c.send(messages.NotesRequestType, messages.NotesRequest{
+		User:  c.User(),
+		Notes: notes,
+	})
+}
+
+// SetPhase moves the room to the agenda phase at index, starting a new
+// round. Only the host can change phase.
+func (c *Client) SetPhase(index int) error {
+	return c.send(messages.PhaseRequestType, messages.PhaseRequest{
+		User:  c.User(),
+		Index: index,
+	})
+}
+
+// SendChat posts a chat message to everyone in the room.
+func (c *Client) SendChat(text string) error {
+	return c.send(messages.ChatMsgType, messages.ChatMessage{
+		User: c.User(),
+		Text: text,
+	})
+}
+
+// React sends one of messages.Reactions to target's row.
+func (c *Client) React(target, emoji string) error {
+	return c.send(messages.ReactionRequestType, messages.ReactionRequest{
+		User:   c.User(),
+		Target: target,
+		Emoji:  emoji,
+	})
 }
 
 func (c *Client) ReadUpdate() any {
@@ -157,16 +441,23 @@ func (c *Client) ReadUpdate() any {
 	switch payload := msg.Payload.(type) {
 	case messages.RoomState:
 		c.logger.Debug("room state message received", "version", payload.Version)
+		c.mu.Lock()
+		defer c.mu.Unlock()
 		if payload.Version <= c.Room.Version {
 			c.logger.Debug("version hasn't changed, continuing")
 			// return early or something
-			return payload.Rolls
+			return payload
 		}
 
 		c.logger.Debug("new version")
-		c.logger.Debug("pushing rolls on channel")
+		c.logger.Debug("pushing room state on channel")
 		c.Room = payload
-		return payload.Rolls
+		return payload
+	case messages.ErrorMessage:
+		c.logger.Error("server sent an error", "code", payload.Code, "message", payload.Message)
+		return payload
+	case error:
+		return payload
 	case messages.DoneRequest:
 		panic("not implemented")
 	default:
@@ -176,6 +467,9 @@ func (c *Client) ReadUpdate() any {
 
 func (c *Client) Close() error {
 	slog.Debug("closing connection")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closing = true
 	err := c.conn.WriteControl(
 		websocket.CloseMessage,
 		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
@@ -191,9 +485,24 @@ func (c *Client) Close() error {
 func (c *Client) updateLoop(updates chan<- messages.Message) {
 	c.logger.Debug("running update loop")
 	for {
-		t, b, err := c.conn.ReadMessage()
+		c.mu.Lock()
+		conn := c.conn
+		c.mu.Unlock()
+		t, b, err := conn.ReadMessage()
+		if err != nil && c.shouldReconnect(err) {
+			c.logger.Error("connection dropped", "error", err)
+			if err = c.reconnect(); err == nil {
+				continue
+			}
+		}
 		if err != nil {
 			c.logger.Error(err.Error())
+			c.mu.Lock()
+			closing := c.closing
+			c.mu.Unlock()
+			if !closing {
+				updates <- messages.Message{Payload: err}
+			}
 			return
 		}
 		if t != websocket.BinaryMessage {
@@ -212,10 +521,62 @@ func (c *Client) updateLoop(updates chan<- messages.Message) {
 			c.logger.Debug("new room version", "version", payload.Version)
 			c.mu.Lock()
 			c.Room = payload
+			if payload.You != "" {
+				c.user = payload.You
+			}
+			if payload.ResumeToken != "" {
+				c.resumeToken = payload.ResumeToken
+			}
 			c.mu.Unlock()
+		case messages.ErrorMessage:
+			c.logger.Debug("error received", "code", payload.Code)
 		default:
 			panic(fmt.Sprintf("support not implemented for %T", payload))
 		}
 		updates <- msg
 	}
 }
+
+// FetchHistory asks the server at endpoint for the stats of every past
+// session of room. Sessions of protected rooms are only included for the
+// password or join code they had.
+func FetchHistory(endpoint, room, password string) (messages.RoomHistory, error) {
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return messages.RoomHistory{}, err
+	}
+	switch parsed.Scheme {
+	case "https", "wss":
+		parsed.Scheme = "https"
+	case "http", "ws":
+		parsed.Scheme = "http"
+	default:
+		return messages.RoomHistory{}, fmt.Errorf("%s is not a valid protocol", parsed.Scheme)
+	}
+	parsed.Path = room + "/history"
+	req, err := http.NewRequest(http.MethodGet, parsed.String(), nil)
+	if err != nil {
+		return messages.RoomHistory{}, err
+	}
+	if password != "" {
+		req.Header.Set(messages.PasswordHeader, password)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return messages.RoomHistory{}, err
+	}
+	defer resp.Body.Close() //nolint: errcheck
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return messages.RoomHistory{}, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var msg messages.Message
+	if err := msgpack.NewDecoder(resp.Body).Decode(&msg); err != nil {
+		return messages.RoomHistory{}, err
+	}
+	history, ok := msg.Payload.(messages.RoomHistory)
+	if !ok {
+		return messages.RoomHistory{}, fmt.Errorf("unexpected message type %d", msg.Type)
+	}
+	return history, nil
+}
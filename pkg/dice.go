@@ -60,9 +60,18 @@ func (dr DiceRoll) String() string {
 }
 
 func (dr DiceRoll) Roll() int {
+	return dr.roll(rand.IntN)
+}
+
+// RollWith rolls using src, so a seeded source gives repeatable rolls.
+func (dr DiceRoll) RollWith(src *rand.Rand) int {
+	return dr.roll(src.IntN)
+}
+
+func (dr DiceRoll) roll(intN func(int) int) int {
 	var result int
 	for x := 0; x < dr.Count; x++ {
-		result += rand.IntN(dr.DiceSides) + 1
+		result += intN(dr.DiceSides) + 1
 	}
 	return result + dr.Modifier
 }
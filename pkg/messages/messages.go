@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/vmihailenco/msgpack/v5"
 )
@@ -19,8 +20,67 @@ const (
 	StateMsgType Type = iota
 	DoneRequestType
 	RollRequestType
+	StatusRequestType
+	MoveUserRequestType
+	LockRequestType
+	ErrorMsgType
+	WatchRequestType
+	ChatMsgType
+	ReactionRequestType
+	VoteRequestType
+	RevealRequestType
+	PollRequestType
+	PollVoteType
+	PickRequestType
+	NotesRequestType
+	HistoryMsgType
+	PhaseRequestType
 )
 
+// Error codes sent in an ErrorMessage.
+const (
+	ErrCodeDuplicateUser = "duplicate_user"
+	ErrCodeRoomClosed    = "room_closed"
+	ErrCodeRoomFull      = "room_full"
+)
+
+// Status is where a participant is in the turn order.
+type Status int
+
+const (
+	StatusWaiting Status = iota
+	StatusDone
+	StatusPassed
+	StatusDeferred
+	StatusAbsent
+	// StatusQueued is a late joiner waiting for the next round.
+	StatusQueued
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusWaiting:
+		return "waiting"
+	case StatusDone:
+		return "done"
+	case StatusPassed:
+		return "passed"
+	case StatusDeferred:
+		return "deferred"
+	case StatusAbsent:
+		return "absent"
+	case StatusQueued:
+		return "queued"
+	default:
+		return fmt.Sprintf("Status(%d)", int(s))
+	}
+}
+
+// IsFinished reports whether the participant no longer needs a turn.
+func (s Status) IsFinished() bool {
+	return s == StatusDone || s == StatusPassed || s == StatusAbsent || s == StatusQueued
+}
+
 type Message struct {
 	_msgpack struct{} `msgpack:",as_array"` //nolint:unused
 	Type     Type     `msgpack:"type"`
@@ -66,6 +126,96 @@ func (m *Message) UnmarshalMsgpack(b []byte) error {
 			return err
 		}
 		m.Payload = roll
+	case StatusRequestType:
+		var status StatusRequest
+		if err = decoder.Decode(&status); err != nil {
+			return err
+		}
+		m.Payload = status
+	case MoveUserRequestType:
+		var move MoveUserRequest
+		if err = decoder.Decode(&move); err != nil {
+			return err
+		}
+		m.Payload = move
+	case LockRequestType:
+		var lock LockRequest
+		if err = decoder.Decode(&lock); err != nil {
+			return err
+		}
+		m.Payload = lock
+	case ErrorMsgType:
+		var e ErrorMessage
+		if err = decoder.Decode(&e); err != nil {
+			return err
+		}
+		m.Payload = e
+	case WatchRequestType:
+		var watch WatchRequest
+		if err = decoder.Decode(&watch); err != nil {
+			return err
+		}
+		m.Payload = watch
+	case ChatMsgType:
+		var chat ChatMessage
+		if err = decoder.Decode(&chat); err != nil {
+			return err
+		}
+		m.Payload = chat
+	case ReactionRequestType:
+		var reaction ReactionRequest
+		if err = decoder.Decode(&reaction); err != nil {
+			return err
+		}
+		m.Payload = reaction
+	case VoteRequestType:
+		var vote VoteRequest
+		if err = decoder.Decode(&vote); err != nil {
+			return err
+		}
+		m.Payload = vote
+	case RevealRequestType:
+		var reveal RevealRequest
+		if err = decoder.Decode(&reveal); err != nil {
+			return err
+		}
+		m.Payload = reveal
+	case PollRequestType:
+		var poll PollRequest
+		if err = decoder.Decode(&poll); err != nil {
+			return err
+		}
+		m.Payload = poll
+	case PollVoteType:
+		var vote PollVote
+		if err = decoder.Decode(&vote); err != nil {
+			return err
+		}
+		m.Payload = vote
+	case PickRequestType:
+		var pick PickRequest
+		if err = decoder.Decode(&pick); err != nil {
+			return err
+		}
+		m.Payload = pick
+	case NotesRequestType:
+		var notes NotesRequest
+		if err = decoder.Decode(&notes); err != nil {
+			return err
+		}
+		m.Payload = notes
+	case HistoryMsgType:
+		var history RoomHistory
+		if err = decoder.Decode(&history); err != nil {
+			return err
+		}
+		m.Payload = history
+	case PhaseRequestType:
+		var phase PhaseRequest
+		if err = decoder.Decode(&phase); err != nil {
+			return err
+		}
+		m.Payload = phase
 	default:
 		panic(fmt.Sprintf("unexpected messages.Type: %#v", m.Type))
 	}
@@ -73,24 +223,302 @@ func (m *Message) UnmarshalMsgpack(b []byte) error {
 }
 
 type RoomState struct {
-	Version int          `msgpack:"version"`
-	Name    string       `msgpack:"name"`
-	Dice    string       `msgpack:"required_roll"`
-	Rolls   []RollResult `msgpack:"rolls"`
+	Version  int    `msgpack:"version"`
+	Name     string `msgpack:"name"`
+	Host     string `msgpack:"host"`
+	Dice     string `msgpack:"required_roll"`
+	Ordering string `msgpack:"ordering"`
+	TieBreak string `msgpack:"tie_break"`
+	LateJoin string `msgpack:"late_join"`
+	// Teams is how teams are ordered, empty if the room ignores them.
+	Teams string `msgpack:"teams,omitempty"`
+	// Mode is "roll" for turn order or "poker" for estimating.
+	Mode string `msgpack:"mode"`
+	// Deck is the cards estimates are chosen from in poker mode.
+	Deck []string `msgpack:"deck,omitempty"`
+	// Revealed is set once the host has turned the estimates over.
+	Revealed bool `msgpack:"revealed"`
+	// Estimate summarises the votes once they are revealed.
+	Estimate *Estimate `msgpack:"estimate,omitempty"`
+	// Protected is set when joining requires a password or join code.
+	Protected bool `msgpack:"protected"`
+	// JoinCode is the generated code to share with others, if there is one.
+	JoinCode string `msgpack:"join_code,omitempty"`
+	// Capacity is the most participants allowed in, zero if unlimited.
+	Capacity int `msgpack:"capacity"`
+	// Locked is set while the host is keeping new users out.
+	Locked bool `msgpack:"locked"`
+	// Spectators is how many connections are watching without rolling.
+	Spectators int `msgpack:"spectators"`
+	// ManualOrder is set once the host has rearranged the turn order by hand.
+	ManualOrder bool         `msgpack:"manual_order"`
+	Rolls       []RollResult `msgpack:"rolls"`
+	// Roster is who the room expects to join.
+	Roster []string `msgpack:"roster,omitempty"`
+	// NotJoined has a row for everyone on the roster who hasn't joined yet,
+	// waiting or marked absent. They take no part in the turn order.
+	NotJoined []RollResult `msgpack:"not_joined,omitempty"`
+	// ClosesAt is when a scheduled room will close, zero for other rooms.
+	ClosesAt time.Time `msgpack:"closes_at"`
+	// Timebox is how long each turn should take, zero for no limit.
+	Timebox time.Duration `msgpack:"timebox"`
+	// Agenda is the meeting's phases, if it has them, and Phase the index
+	// of the current one. Mode, Dice and Timebox are the current phase's.
+	Agenda []Phase `msgpack:"agenda,omitempty"`
+	Phase  int     `msgpack:"phase"`
+	// TurnStarted is when the current speaker's turn began.
+	TurnStarted time.Time `msgpack:"turn_started"`
+	// Chat is the room's most recent chat, oldest first.
+	Chat []ChatMessage `msgpack:"chat"`
+	// Poll is the host's open question, if there is one.
+	Poll *Poll `msgpack:"poll,omitempty"`
+	// Pick is who was last picked for a role.
+	Pick *Pick `msgpack:"pick,omitempty"`
+
+	// You is the name the receiving client is known by in the room.
+	You string `msgpack:"you,omitempty"`
+	// ResumeToken lets the receiving client reclaim its place if its
+	// connection drops.
+	ResumeToken string `msgpack:"resume_token,omitempty"`
+	// YourVote is the receiving client's own estimate, even while hidden.
+	YourVote string `msgpack:"your_vote,omitempty"`
 }
 
 type RollRequest struct {
-	User string `msgpack:"user"`
-	Roll string `msgpack:"roll"`
+	User     string `msgpack:"user"`
+	Roll     string `msgpack:"roll"`
+	Modifier int    `msgpack:"modifier"`
+	// Team is the team the user belongs to, if any.
+	Team string `msgpack:"team,omitempty"`
+	// ResumeToken reclaims a place held since a dropped connection.
+	ResumeToken string `msgpack:"resume_token,omitempty"`
 }
 
 type RollResult struct {
-	User   string `msgpack:"user"`
-	ID     uint32 `msgpack:"id"`
-	Result int    `msgpack:"result"`
-	IsDone bool   `msgpack:"is_done"`
+	User     string `msgpack:"user"`
+	ID       uint32 `msgpack:"id"`
+	Result   int    `msgpack:"result"`
+	Modifier int    `msgpack:"modifier"`
+	// RollOff is an extra d20 rolled to settle a tie, zero if none was needed.
+	RollOff  int       `msgpack:"roll_off"`
+	Status   Status    `msgpack:"status"`
+	JoinedAt time.Time `msgpack:"joined_at"`
+	// Late is set for anyone who joined after the round got going.
+	Late bool `msgpack:"late"`
+	// Disconnected is set while a dropped participant's place is held for
+	// them to reconnect.
+	Disconnected bool `msgpack:"disconnected"`
+	// Reactions counts the reactions others have sent, by emoji.
+	Reactions map[string]int `msgpack:"reactions,omitempty"`
+	// Voted is set once the participant has picked an estimate.
+	Voted bool `msgpack:"voted"`
+	// Vote is the participant's estimate, blank until the host reveals.
+	Vote string `msgpack:"vote,omitempty"`
+	// Team is the participant's team, empty if they have none.
+	Team string `msgpack:"team,omitempty"`
+	// Notes are the participant's standup notes, if they wrote any.
+	Notes *StandupNotes `msgpack:"notes,omitempty"`
 }
 
 type DoneRequest struct {
 	User string `msgpack:"user"`
 }
+
+// StatusRequest sets the status of Target, or of User when Target is empty.
+// Only the host may change someone else's status or mark anyone absent.
+type StatusRequest struct {
+	User   string `msgpack:"user"`
+	Target string `msgpack:"target"`
+	Status Status `msgpack:"status"`
+}
+
+// MoveAction is how a MoveUserRequest rearranges the turn order.
+type MoveAction int
+
+const (
+	MoveToIndex MoveAction = iota
+	MoveUp
+	MoveDown
+	MoveToTop
+	// MoveReset drops the manual order and goes back to the room's ordering.
+	MoveReset
+)
+
+// MoveUserRequest lets the host move Target within the turn order. Once
+// anyone has been moved the order is kept as arranged until it is reset.
+type MoveUserRequest struct {
+	User   string     `msgpack:"user"`
+	Target string     `msgpack:"target"`
+	Action MoveAction `msgpack:"action"`
+	Index  int        `msgpack:"index"`
+}
+
+// LockRequest lets the host stop, or again allow, new users joining.
+type LockRequest struct {
+	User   string `msgpack:"user"`
+	Locked bool   `msgpack:"locked"`
+}
+
+// WatchRequest is sent in place of a RollRequest to follow a room as a
+// spectator, without taking part in the turn order.
+type WatchRequest struct {
+	// Name is only used to identify the spectator in server logs.
+	Name string `msgpack:"name"`
+}
+
+// ChatMessage is a line of chat sent to everyone in the room. The server
+// sets User and At when it accepts the message.
+type ChatMessage struct {
+	User string    `msgpack:"user"`
+	Text string    `msgpack:"text"`
+	At   time.Time `msgpack:"at"`
+}
+
+// Reactions are the emoji participants can send each other.
+var Reactions = []string{"👏", "🎉", "👍", "❤️"}
+
+// ReactionRequest sends Emoji, one of Reactions, to Target's row.
+type ReactionRequest struct {
+	User   string `msgpack:"user"`
+	Target string `msgpack:"target"`
+	Emoji  string `msgpack:"emoji"`
+}
+
+// VoteRequest picks Card from the room's deck as User's estimate. An empty
+// Card takes the vote back.
+type VoteRequest struct {
+	User string `msgpack:"user"`
+	Card string `msgpack:"card"`
+}
+
+// RevealRequest turns the estimates over or, when Reveal is false, clears
+// them for the next item. Only the host may send it.
+type RevealRequest struct {
+	User   string `msgpack:"user"`
+	Reveal bool   `msgpack:"reveal"`
+}
+
+// Estimate summarises a revealed round of planning poker. Votes of "?" are
+// left out.
+type Estimate struct {
+	Votes int `msgpack:"votes"`
+	// Average is only set when the votes are numbers.
+	Average *float64 `msgpack:"average,omitempty"`
+	Median  string   `msgpack:"median"`
+	// Consensus is set when everyone picked the same card.
+	Consensus bool `msgpack:"consensus"`
+}
+
+// PollRequest asks the room a question, replacing any poll already open.
+// FistOfFive asks for a 1-5 rating instead of choosing between Options, and
+// Anonymous keeps who voted for what to the server. A request with no
+// Question closes the current poll. Only the host may send it.
+type PollRequest struct {
+	User       string   `msgpack:"user"`
+	Question   string   `msgpack:"question"`
+	Options    []string `msgpack:"options"`
+	FistOfFive bool     `msgpack:"fist_of_five"`
+	Anonymous  bool     `msgpack:"anonymous"`
+}
+
+// PollVote is User's choice, an index into the poll's options. Everyone
+// votes once.
+type PollVote struct {
+	User   string `msgpack:"user"`
+	Option int    `msgpack:"option"`
+}
+
+// Poll is an open question with its live results.
+type Poll struct {
+	// ID changes every time the host asks something new.
+	ID         int      `msgpack:"id"`
+	Question   string   `msgpack:"question"`
+	Options    []string `msgpack:"options"`
+	FistOfFive bool     `msgpack:"fist_of_five"`
+	Anonymous  bool     `msgpack:"anonymous"`
+	// Tallies counts the votes for each option.
+	Tallies []int `msgpack:"tallies"`
+	// Voters lists who has voted, alphabetically.
+	Voters []string `msgpack:"voters"`
+	// Choices lists who voted for each option, unless the poll is anonymous.
+	Choices [][]string `msgpack:"choices,omitempty"`
+}
+
+// PickRequest asks the server to choose Count present participants, one if
+// zero, for Role. Only the host may send it.
+type PickRequest struct {
+	User  string `msgpack:"user"`
+	Count int    `msgpack:"count"`
+	Role  string `msgpack:"role"`
+}
+
+// Pick is the outcome of a PickRequest.
+type Pick struct {
+	Role  string    `msgpack:"role"`
+	Users []string  `msgpack:"users"`
+	At    time.Time `msgpack:"at"`
+}
+
+// StandupNotes are what a participant plans to say in their turn.
+type StandupNotes struct {
+	Yesterday string `msgpack:"yesterday"`
+	Today     string `msgpack:"today"`
+	Blockers  string `msgpack:"blockers"`
+}
+
+// IsZero reports whether no notes were written.
+func (n StandupNotes) IsZero() bool {
+	return n == StandupNotes{}
+}
+
+// NotesRequest replaces User's standup notes. Empty notes clear them.
+type NotesRequest struct {
+	User  string       `msgpack:"user"`
+	Notes StandupNotes `msgpack:"notes"`
+}
+
+// PhaseRequest moves the room to the agenda phase at Index, starting a new
+// round. Only the host can change phase.
+type PhaseRequest struct {
+	User  string `msgpack:"user"`
+	Index int    `msgpack:"index"`
+}
+
+// RoomHistory is how everyone has fared across past sessions of rooms
+// with the same name.
+type RoomHistory struct {
+	Room string `msgpack:"room"`
+	// Sessions is how many sessions have been recorded.
+	Sessions int           `msgpack:"sessions"`
+	Users    []UserHistory `msgpack:"users"`
+}
+
+// UserHistory is one user's record in a room, oldest session first.
+type UserHistory struct {
+	User    string         `msgpack:"user"`
+	Entries []HistoryEntry `msgpack:"entries"`
+}
+
+// HistoryEntry is how a user did in one session.
+type HistoryEntry struct {
+	// At is when the session started.
+	At     time.Time `msgpack:"at"`
+	Result int       `msgpack:"result"`
+	// Position is where they came in the final order, from 1, out of Of.
+	Position int `msgpack:"position"`
+	Of       int `msgpack:"of"`
+	// TimeToDone is how far into the session they finished, zero if they
+	// never did.
+	TimeToDone time.Duration `msgpack:"time_to_done"`
+	Status     Status        `msgpack:"status"`
+}
+
+// ErrorMessage tells a client why the server refused or dropped it.
+type ErrorMessage struct {
+	Code    string `msgpack:"code"`
+	Message string `msgpack:"message"`
+}
+
+func (e ErrorMessage) Error() string {
+	return e.Message
+}
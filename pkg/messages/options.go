@@ -0,0 +1,174 @@
+package messages
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// PasswordHeader carries the room password, or join code, on the WebSocket
+// handshake so it stays out of URLs and request logs.
+const PasswordHeader = "X-TTT-Password"
+
+// ResumeHeader carries a resume token when redialling, so a held place can
+// be reclaimed in a room that has since been locked or filled.
+const ResumeHeader = "X-TTT-Resume"
+
+// WatchHeader marks a handshake from a spectator, who takes no place and so
+// isn't held to the room's lock or capacity.
+const WatchHeader = "X-TTT-Watch"
+
+// RoomOptions are the settings a client asks for when its connection creates
+// a room. They travel as query parameters on the room URL and are ignored
+// when the room already exists. Empty fields leave the server's default.
+type RoomOptions struct {
+	// Template names a set of options on the server to start from.
+	Template string
+	// Dice is what participants roll, such as "1d20" or "2d6+1".
+	Dice     string
+	Ordering string
+	TieBreak string
+	LateJoin string
+	// Teams groups participants by team, ordering teams by "captain" or
+	// "average" roll.
+	Teams string
+	// Mode is what the room is for, such as "roll" or "poker".
+	Mode string
+	// Deck is the planning poker deck, such as "fibonacci" or "tshirt".
+	Deck string
+	// Timebox is how long each turn should take, such as "2m".
+	Timebox string
+	// Agenda splits the meeting into phases the host moves through.
+	Agenda []Phase
+	// Roster is who the room expects to join.
+	Roster []string
+	// Capacity caps the number of participants; zero means no limit.
+	Capacity int
+	// JoinCode asks the server to generate a code others must join with.
+	JoinCode bool
+	// Password protects the room. It is sent in PasswordHeader rather than
+	// the query string.
+	Password string
+}
+
+func (o RoomOptions) Values() url.Values {
+	v := url.Values{}
+	if o.Template != "" {
+		v.Set("template", o.Template)
+	}
+	if o.Dice != "" {
+		v.Set("dice", o.Dice)
+	}
+	if o.Ordering != "" {
+		v.Set("order", o.Ordering)
+	}
+	if o.TieBreak != "" {
+		v.Set("tiebreak", o.TieBreak)
+	}
+	if o.LateJoin != "" {
+		v.Set("late", o.LateJoin)
+	}
+	if o.Teams != "" {
+		v.Set("teams", o.Teams)
+	}
+	if o.Mode != "" {
+		v.Set("mode", o.Mode)
+	}
+	if o.Deck != "" {
+		v.Set("deck", o.Deck)
+	}
+	if o.Timebox != "" {
+		v.Set("timebox", o.Timebox)
+	}
+	if len(o.Agenda) > 0 {
+		v.Set("agenda", FormatAgenda(o.Agenda))
+	}
+	if len(o.Roster) > 0 {
+		v.Set("roster", strings.Join(o.Roster, ","))
+	}
+	if o.Capacity > 0 {
+		v.Set("max", strconv.Itoa(o.Capacity))
+	}
+	if o.JoinCode {
+		v.Set("join_code", "true")
+	}
+	return v
+}
+
+func ParseRoomOptions(v url.Values) (RoomOptions, error) {
+	opts := RoomOptions{
+		Template: v.Get("template"),
+		Timebox:  v.Get("timebox"),
+		Dice:     v.Get("dice"),
+		Ordering: v.Get("order"),
+		TieBreak: v.Get("tiebreak"),
+		LateJoin: v.Get("late"),
+		Teams:    v.Get("teams"),
+		Mode:     v.Get("mode"),
+		Deck:     v.Get("deck"),
+	}
+	if s := v.Get("roster"); s != "" {
+		opts.Roster = strings.Split(s, ",")
+	}
+	var err error
+	if s := v.Get("agenda"); s != "" {
+		if opts.Agenda, err = ParseAgenda(s); err != nil {
+			return opts, err
+		}
+	}
+	if s := v.Get("max"); s != "" {
+		if opts.Capacity, err = strconv.Atoi(s); err != nil || opts.Capacity < 0 {
+			return opts, fmt.Errorf("invalid capacity %q", s)
+		}
+	}
+	if s := v.Get("join_code"); s != "" {
+		if opts.JoinCode, err = strconv.ParseBool(s); err != nil {
+			return opts, fmt.Errorf("invalid join_code %q", s)
+		}
+	}
+	return opts, nil
+}
+
+// Phase is one part of a meeting's agenda. Empty fields keep the setting
+// the room was created with.
+type Phase struct {
+	Name    string `msgpack:"name" json:"name"`
+	Mode    string `msgpack:"mode,omitempty" json:"mode,omitempty"`
+	Dice    string `msgpack:"dice,omitempty" json:"dice,omitempty"`
+	Timebox string `msgpack:"timebox,omitempty" json:"timebox,omitempty"`
+}
+
+// ParseAgenda reads phases written as "name:mode:dice:timebox", separated by
+// commas, such as "standup:roll:1d20:2m, parking lot, retro:poker". Anything
+// after the name may be left out or empty.
+func ParseAgenda(s string) ([]Phase, error) {
+	var agenda []Phase
+	for _, part := range strings.Split(s, ",") {
+		fields := strings.Split(part, ":")
+		if len(fields) > 4 {
+			return nil, fmt.Errorf("invalid phase %q: too many fields", strings.TrimSpace(part))
+		}
+		fields = append(fields, make([]string, 4-len(fields))...)
+		phase := Phase{
+			Name:    strings.TrimSpace(fields[0]),
+			Mode:    strings.TrimSpace(fields[1]),
+			Dice:    strings.TrimSpace(fields[2]),
+			Timebox: strings.TrimSpace(fields[3]),
+		}
+		if phase.Name == "" {
+			return nil, fmt.Errorf("invalid phase %q: no name", strings.TrimSpace(part))
+		}
+		agenda = append(agenda, phase)
+	}
+	return agenda, nil
+}
+
+// FormatAgenda writes phases the way ParseAgenda reads them.
+func FormatAgenda(agenda []Phase) string {
+	parts := make([]string, len(agenda))
+	for idx, p := range agenda {
+		parts[idx] = strings.TrimRight(strings.Join([]string{p.Name, p.Mode, p.Dice, p.Timebox}, ":"), ":")
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrPasswordRequired = errors.New("room requires a password or join code")
+	ErrWrongPassword    = errors.New("incorrect password or join code")
+	ErrRoomFull         = errors.New("room is full")
+	ErrRoomLocked       = errors.New("room is locked by the host")
+)
+
+const (
+	joinCodeLength = 6
+	// joinCodeAlphabet leaves out characters that are easy to misread.
+	joinCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+func newJoinCode() string {
+	b := make([]byte, joinCodeLength)
+	_, _ = rand.Read(b)
+	for idx := range b {
+		b[idx] = joinCodeAlphabet[int(b[idx])%len(joinCodeAlphabet)]
+	}
+	return string(b)
+}
+
+// checkPassword reports whether password lets someone into the room.
+func (r *Room) checkPassword(password string) error {
+	if r.secret == "" {
+		return nil
+	}
+	if password == "" {
+		return ErrPasswordRequired
+	}
+	if subtle.ConstantTimeCompare([]byte(password), []byte(r.secret)) != 1 {
+		return ErrWrongPassword
+	}
+	return nil
+}
+
+// checkEntry reports whether someone can come into the room. A resume token
+// for a place in it gets past the lock and capacity, so those who dropped
+// can always get back.
+func (r *Room) checkEntry(resumeToken string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.resumer(resumeToken); ok {
+		return nil
+	}
+	return r.checkCapacity()
+}
+
+// checkCapacity reports whether there is space for someone new to join.
+// The caller must hold r.mu.
+func (r *Room) checkCapacity() error {
+	if r.Locked {
+		return ErrRoomLocked
+	}
+	if taken := r.placesTaken(); r.Capacity > 0 && taken >= r.Capacity {
+		return fmt.Errorf("%w: %d of %d places taken", ErrRoomFull, taken, r.Capacity)
+	}
+	return nil
+}
+
+// placesTaken counts those connected and those whose place is held while
+// they reconnect. Anyone who left for good doesn't count, even though their
+// row stays. Sessions are counted rather than rolls, as a session takes its
+// place before its roll is made. The caller must hold r.mu.
+func (r *Room) placesTaken() int {
+	taken := make(map[string]bool)
+	for _, session := range r.userSessions {
+		if !session.spectator {
+			taken[session.name] = true
+		}
+	}
+	for user, roll := range r.Rolls {
+		if roll.Disconnected {
+			taken[user] = true
+		}
+	}
+	return len(taken)
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/abennett/ttt/pkg/messages"
+)
+
+const (
+	// ChatHistory is how many chat messages a room keeps.
+	ChatHistory = 50
+	// MaxChatLength is the longest chat message accepted, in characters.
+	MaxChatLength = 280
+)
+
+var (
+	ErrChatEmpty   = errors.New("chat message is empty")
+	ErrChatTooLong = errors.New("chat message is too long")
+)
+
+// addChat appends a chat message, stamped when it arrived, dropping the
+// oldest once the history is full. The caller must hold r.mu.
+func (r *Room) addChat(msg messages.ChatMessage) error {
+	if _, ok := r.Rolls[msg.User]; !ok {
+		return fmt.Errorf("user %q does not exist", msg.User)
+	}
+	msg.Text = strings.TrimSpace(msg.Text)
+	if msg.Text == "" {
+		return ErrChatEmpty
+	}
+	if utf8.RuneCountInString(msg.Text) > MaxChatLength {
+		return ErrChatTooLong
+	}
+
+	r.chat = append(r.chat, msg)
+	if len(r.chat) > ChatHistory {
+		r.chat = r.chat[len(r.chat)-ChatHistory:]
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var (
+	ErrUnknownDuplicatePolicy = errors.New("unknown duplicate username policy")
+	ErrDuplicateUser          = errors.New("username is already in the room")
+)
+
+// DuplicatePolicy decides what happens when someone joins under a name that
+// already has a live session in the room.
+type DuplicatePolicy string
+
+const (
+	// DuplicateReject turns the newcomer away.
+	DuplicateReject DuplicatePolicy = "reject"
+	// DuplicateSuffix renames the newcomer Alice#2, Alice#3 and so on.
+	DuplicateSuffix DuplicatePolicy = "suffix"
+	// DuplicateShare treats the newcomer as the same person on another
+	// device, sharing one roll.
+	DuplicateShare DuplicatePolicy = "share"
+)
+
+func ParseDuplicatePolicy(s string) (DuplicatePolicy, error) {
+	switch p := DuplicatePolicy(s); p {
+	case "":
+		return DuplicateReject, nil
+	case DuplicateReject, DuplicateSuffix, DuplicateShare:
+		return p, nil
+	default:
+		return "", fmt.Errorf("%w %q", ErrUnknownDuplicatePolicy, s)
+	}
+}
+
+// claimName picks the name a new session will use for user. Someone
+// rejoining after leaving gets their old place back, but a place held for
+// someone who dropped is only given back for its resume token.
+// The caller must hold r.mu.
+func (r *Room) claimName(user string) (string, error) {
+	roll, ok := r.Rolls[user]
+	held := ok && roll.Disconnected
+	if !held && !r.isConnected(user) {
+		return user, nil
+	}
+	switch {
+	case r.duplicates == DuplicateShare && !held:
+		return user, nil
+	case r.duplicates == DuplicateSuffix:
+		for n := 2; ; n++ {
+			name := user + "#" + strconv.Itoa(n)
+			if _, taken := r.Rolls[name]; !taken && !r.isConnected(name) {
+				return name, nil
+			}
+		}
+	default:
+		return "", fmt.Errorf("%w: %q", ErrDuplicateUser, user)
+	}
+}
+
+// isConnected reports whether user has any live session in the room.
+// The caller must hold r.mu.
+func (r *Room) isConnected(user string) bool {
+	for _, session := range r.userSessions {
+		if !session.spectator && session.name == user {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,280 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/abennett/ttt/pkg/messages"
+)
+
+// createdEvent starts every log with the room as it was created or restored.
+const createdEvent = "created"
+
+// redactedSecret stands in for the secret of a protected room being replayed.
+const redactedSecret = "redacted"
+
+var ErrBadEventLog = errors.New("bad event log")
+
+// Event is one accepted update to a room, in the order it was applied.
+type Event struct {
+	Version int       `json:"version"`
+	At      time.Time `json:"at"`
+	Type    string    `json:"type"`
+	// Room, Seed, Grace and Protected are only set on the created event that
+	// starts a log: the room to apply the rest to, without its secrets, the
+	// seed for its random choices, the grace period dropped participants are
+	// held for and whether the room had a password or join code.
+	Room      *RoomSnapshot   `json:"room,omitempty"`
+	Seed      uint64          `json:"seed,omitempty"`
+	Grace     time.Duration   `json:"grace,omitempty"`
+	Protected bool            `json:"protected,omitempty"`
+	Update    json.RawMessage `json:"update,omitempty"`
+}
+
+// EventLog is an append-only record of a room's events.
+type EventLog interface {
+	Append(e Event) error
+	Close() error
+}
+
+// eventName is what an event is called in a log.
+func eventName(event any) (string, error) {
+	switch event.(type) {
+	case messages.RollResult:
+		return "join", nil
+	case messages.DoneRequest:
+		return "done", nil
+	case messages.StatusRequest:
+		return "status", nil
+	case messages.MoveUserRequest:
+		return "move", nil
+	case messages.LockRequest:
+		return "lock", nil
+	case messages.ChatMessage:
+		return "chat", nil
+	case messages.ReactionRequest:
+		return "reaction", nil
+	case messages.VoteRequest:
+		return "vote", nil
+	case messages.RevealRequest:
+		return "reveal", nil
+	case messages.PollRequest:
+		return "poll", nil
+	case messages.PollVote:
+		return "poll_vote", nil
+	case picked:
+		return "pick", nil
+	case messages.NotesRequest:
+		return "notes", nil
+	case messages.PhaseRequest:
+		return "phase", nil
+	case spectatorsChanged:
+		return "spectators", nil
+	case left:
+		return "leave", nil
+	case expired:
+		return "expired", nil
+	default:
+		return "", fmt.Errorf("unknown event type: %T", event)
+	}
+}
+
+func decodeAs[T any](raw json.RawMessage) (any, error) {
+	var event T
+	err := json.Unmarshal(raw, &event)
+	return event, err
+}
+
+var eventDecoders = map[string]func(json.RawMessage) (any, error){
+	"join":       decodeAs[messages.RollResult],
+	"done":       decodeAs[messages.DoneRequest],
+	"status":     decodeAs[messages.StatusRequest],
+	"move":       decodeAs[messages.MoveUserRequest],
+	"lock":       decodeAs[messages.LockRequest],
+	"chat":       decodeAs[messages.ChatMessage],
+	"reaction":   decodeAs[messages.ReactionRequest],
+	"vote":       decodeAs[messages.VoteRequest],
+	"reveal":     decodeAs[messages.RevealRequest],
+	"poll":       decodeAs[messages.PollRequest],
+	"poll_vote":  decodeAs[messages.PollVote],
+	"pick":       decodeAs[picked],
+	"notes":      decodeAs[messages.NotesRequest],
+	"phase":      decodeAs[messages.PhaseRequest],
+	"spectators": decodeAs[spectatorsChanged],
+	"leave":      decodeAs[left],
+	"expired":    decodeAs[expired],
+}
+
+// WithEventLogs keeps a log of every room's events in dir, one file per room
+// each time it is created or restored.
+func WithEventLogs(dir string) Option {
+	return func(s *Server) {
+		s.eventDir = dir
+	}
+}
+
+// startEventLog opens a log for the room and records it as created.
+// The caller must hold r.mu.
+func (s *Server) startEventLog(r *Room) {
+	if s.eventDir == "" {
+		return
+	}
+	name := fmt.Sprintf("%s-%s.jsonl", url.PathEscape(r.Name), time.Now().UTC().Format("20060102T150405.000"))
+	log, err := NewFileEventLog(filepath.Join(s.eventDir, name))
+	if err != nil {
+		r.logger.Error("failed opening event log", "error", err)
+		return
+	}
+	// A log is for looking back at a meeting, not for getting back into it
+	snap := r.snapshot()
+	snap.Secret, snap.JoinCode, snap.ResumeTokens = "", "", nil
+	err = log.Append(Event{
+		Version:   r.Version,
+		At:        time.Now(),
+		Type:      createdEvent,
+		Room:      &snap,
+		Seed:      r.seed,
+		Grace:     r.gracePeriod,
+		Protected: r.secret != "",
+	})
+	if err != nil {
+		r.logger.Error("failed writing event log", "error", err)
+		_ = log.Close()
+		return
+	}
+	r.events = log
+}
+
+// record appends an event applied at the given time to the room's log, if
+// it keeps one. A failed write is logged rather than failing the update.
+// The caller must hold r.mu.
+func (r *Room) record(event any, at time.Time) {
+	if r.events == nil {
+		return
+	}
+	name, err := eventName(event)
+	if err != nil {
+		r.logger.Error("failed recording event", "error", err)
+		return
+	}
+	raw, err := json.Marshal(event)
+	if err != nil {
+		r.logger.Error("failed recording event", "error", err)
+		return
+	}
+	err = r.events.Append(Event{
+		Version: r.Version,
+		At:      at,
+		Type:    name,
+		Update:  raw,
+	})
+	if err != nil {
+		r.logger.Error("failed recording event", "error", err)
+	}
+}
+
+// FileEventLog writes events to a file as JSON lines.
+type FileEventLog struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// NewFileEventLog starts a log at path, creating the directory if needed.
+func NewFileEventLog(path string) (*FileEventLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileEventLog{f: f}, nil
+}
+
+func (l *FileEventLog) Append(e Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.f.Write(append(b, '\n'))
+	return err
+}
+
+func (l *FileEventLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// ReplayFrame is the room as it stood after one event.
+type ReplayFrame struct {
+	At    time.Time
+	State messages.RoomState
+}
+
+// Replay reads an event log and rebuilds the room's state after each event,
+// starting with the room as it was created.
+func Replay(r io.Reader) ([]ReplayFrame, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	var (
+		room   *Room
+		frames []ReplayFrame
+	)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrBadEventLog, err)
+		}
+		if room == nil {
+			if e.Type != createdEvent || e.Room == nil {
+				return nil, fmt.Errorf("%w: does not start with the room being created", ErrBadEventLog)
+			}
+			var err error
+			room, err = NewServer(WithGracePeriod(e.Grace)).restoreRoom(*e.Room)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrBadEventLog, err)
+			}
+			room.replaying = true
+			if e.Protected {
+				// The secret isn't logged, but the room still shows as protected
+				room.secret = redactedSecret
+			}
+			room.seed = e.Seed
+			room.rng = rand.New(rand.NewPCG(e.Seed, e.Seed))
+		} else {
+			decode, ok := eventDecoders[e.Type]
+			if !ok {
+				return nil, fmt.Errorf("%w: unknown event %q", ErrBadEventLog, e.Type)
+			}
+			event, err := decode(e.Update)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrBadEventLog, err)
+			}
+			if err := room.apply(event); err != nil {
+				return nil, fmt.Errorf("%w: version %d: %w", ErrBadEventLog, e.Version, err)
+			}
+			room.Version = e.Version
+			room.trackTurn(e.At)
+		}
+		frames = append(frames, ReplayFrame{At: e.At, State: room.ToState()})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if room == nil {
+		return nil, fmt.Errorf("%w: no events", ErrBadEventLog)
+	}
+	return frames, nil
+}
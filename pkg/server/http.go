@@ -15,6 +15,9 @@ func NewMux(server *Server) http.Handler {
 	r := chi.NewRouter()
 	r.Use(middleware.DefaultLogger)
 	r.Get("/{roomName}", server.ServeHTTP)
+	r.Get("/{roomName}/history", server.ServeHistory)
 	r.Get("/health", health)
+	r.Get("/templates", server.ServeTemplates)
+	r.Put("/templates/{templateName}", server.ServeSaveTemplate)
 	return r
 }
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrRoomClosed is returned when joining a room that is being closed.
+var ErrRoomClosed = errors.New("room is closed")
+
+// closeTimeout is how long a session being closed has to answer the close
+// message before its connection is dropped.
+const closeTimeout = time.Second
+
+// WithIdleTimeout closes rooms that have had no update for d. Zero, the
+// default, leaves idle rooms open.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.idleTimeout = d
+	}
+}
+
+// WithRoomTTL closes rooms d after they were created, however busy they
+// are. Zero, the default, sets no limit.
+func WithRoomTTL(d time.Duration) Option {
+	return func(s *Server) {
+		s.roomTTL = d
+	}
+}
+
+// RunJanitor closes rooms that have been idle or open for too long, until
+// ctx is done. It returns straight away if neither limit is set.
+func (s *Server) RunJanitor(ctx context.Context) {
+	interval := s.janitorInterval()
+	if interval == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.sweep(now)
+		}
+	}
+}
+
+// janitorInterval checks a few times within the shortest limit, and at
+// least once a minute.
+func (s *Server) janitorInterval() time.Duration {
+	var shortest time.Duration
+	for _, d := range []time.Duration{s.idleTimeout, s.roomTTL} {
+		if d > 0 && (shortest == 0 || d < shortest) {
+			shortest = d
+		}
+	}
+	if shortest == 0 {
+		return 0
+	}
+	return min(shortest/4, time.Minute)
+}
+
+// sweep closes every room past its limits. The rooms are gathered first so
+// s.rw is never held while taking a room's lock.
+func (s *Server) sweep(now time.Time) {
+	s.rw.RLock()
+	rooms := make([]*Room, 0, len(s.rooms))
+	for _, room := range s.rooms {
+		rooms = append(rooms, room)
+	}
+	s.rw.RUnlock()
+
+	for _, room := range rooms {
+		room.mu.Lock()
+		if reason := s.expiry(room, now); reason != "" {
+			room.close(reason)
+		}
+		room.mu.Unlock()
+	}
+}
+
+// expiry explains why room should be closed at now, or returns "" if it
+// can stay open. The caller must hold r.mu.
+func (s *Server) expiry(r *Room, now time.Time) string {
+	switch {
+	case r.closed, now.Before(r.closesAt):
+		// Scheduled rooms are closed when their slot ends
+		return ""
+	case s.roomTTL > 0 && now.Sub(r.createdAt) >= s.roomTTL:
+		return fmt.Sprintf("room reached its time limit of %s", s.roomTTL)
+	case s.idleTimeout > 0 && now.Sub(r.lastActive) >= s.idleTimeout:
+		return fmt.Sprintf("room was idle for %s", s.idleTimeout)
+	default:
+		return ""
+	}
+}
+
+// close shuts the room, telling every session why before disconnecting it.
+// No further updates are applied. The caller must hold r.mu.
+func (r *Room) close(reason string) {
+	r.closed = true
+	for user, timer := range r.graceTimers {
+		timer.Stop()
+		delete(r.graceTimers, user)
+	}
+	for _, session := range r.userSessions {
+		select {
+		case session.closeCh <- reason:
+		default:
+		}
+	}
+	r.logger.Info("closing room", "reason", reason)
+	if r.onEmpty != nil {
+		r.onEmpty()
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/abennett/ttt/pkg/messages"
+)
+
+var (
+	ErrUnknownLateJoin = errors.New("unknown late-join policy")
+	ErrQueued          = errors.New("waiting for the next round")
+)
+
+// LateJoin decides where someone who joins mid-round is placed.
+type LateJoin string
+
+const (
+	// LateJoinInsert sorts latecomers by their roll like everyone else.
+	LateJoinInsert LateJoin = "insert"
+	// LateJoinAppend puts latecomers after everyone who was on time.
+	LateJoinAppend LateJoin = "append"
+	// LateJoinQueue holds latecomers back until the next round.
+	LateJoinQueue LateJoin = "queue"
+)
+
+func ParseLateJoin(s string) (LateJoin, error) {
+	switch lj := LateJoin(s); lj {
+	case "":
+		return LateJoinInsert, nil
+	case LateJoinInsert, LateJoinAppend, LateJoinQueue:
+		return lj, nil
+	default:
+		return "", fmt.Errorf("%w %q", ErrUnknownLateJoin, s)
+	}
+}
+
+// Compare orders two participants in the same band of the room's Ordering.
+func (lj LateJoin) Compare(a, b messages.RollResult) int {
+	if lj != LateJoinAppend {
+		return 0
+	}
+	switch {
+	case a.Late && b.Late:
+		return a.JoinedAt.Compare(b.JoinedAt)
+	case a.Late:
+		return 1
+	case b.Late:
+		return -1
+	default:
+		return 0
+	}
+}
+
+// admit marks a new participant as late if the round is already under way
+// and, depending on the policy, queues them for the next one.
+// The caller must hold r.mu.
+func (r *Room) admit(rr *messages.RollResult) {
+	if !r.roundStarted() {
+		return
+	}
+	rr.Late = true
+	if r.LateJoin == LateJoinQueue {
+		rr.Status = messages.StatusQueued
+	}
+	r.logger.Debug("late joiner", "user", rr.User, "policy", r.LateJoin)
+}
+
+// roundStarted reports whether anyone has taken their turn yet.
+// The caller must hold r.mu.
+func (r *Room) roundStarted() bool {
+	for _, roll := range r.Rolls {
+		if roll.Status == messages.StatusDone || roll.Status == messages.StatusPassed {
+			return true
+		}
+	}
+	return false
+}
+
+// letQueueIn starts the next round for queued latecomers once everyone who
+// was on time has finished. The caller must hold r.mu.
+func (r *Room) letQueueIn() {
+	var queued []*messages.RollResult
+	for _, roll := range r.Rolls {
+		switch {
+		case roll.Status == messages.StatusQueued:
+			queued = append(queued, roll)
+		case !roll.Status.IsFinished():
+			return
+		}
+	}
+	for _, roll := range queued {
+		roll.Status = messages.StatusWaiting
+		r.logger.Debug("let in from the queue", "user", roll.User)
+	}
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/abennett/ttt/pkg/messages"
+)
+
+// MaxNoteLength is the longest each standup note can be, in characters.
+const MaxNoteLength = 280
+
+var ErrNoteTooLong = errors.New("standup note is too long")
+
+// setNotes replaces a participant's standup notes. The caller must hold r.mu.
+func (r *Room) setNotes(req messages.NotesRequest) error {
+	user, ok := r.Rolls[req.User]
+	if !ok {
+		return fmt.Errorf("user %q does not exist", req.User)
+	}
+	notes := messages.StandupNotes{
+		Yesterday: strings.TrimSpace(req.Notes.Yesterday),
+		Today:     strings.TrimSpace(req.Notes.Today),
+		Blockers:  strings.TrimSpace(req.Notes.Blockers),
+	}
+	for _, note := range []string{notes.Yesterday, notes.Today, notes.Blockers} {
+		if utf8.RuneCountInString(note) > MaxNoteLength {
+			return ErrNoteTooLong
+		}
+	}
+	if notes.IsZero() {
+		user.Notes = nil
+		return nil
+	}
+	user.Notes = &notes
+	return nil
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+
+	"github.com/abennett/ttt/pkg/messages"
+)
+
+var ErrUnknownOrdering = errors.New("unknown ordering")
+
+const (
+	OrderHighestFirst = "highest"
+	OrderLowestFirst  = "lowest"
+	OrderJoin         = "join"
+	OrderAlphabetical = "alpha"
+	OrderShuffle      = "shuffle"
+	OrderDoneLast     = "donelast"
+)
+
+// Ordering decides the turn order of a room's participants. Participants are
+// sorted by Rank first and by Compare within the same rank; anything still
+// equal is settled by the room's TieBreak and then join order.
+type Ordering interface {
+	Name() string
+	// Rank places a participant into a band; lower bands go first.
+	Rank(rr messages.RollResult) int
+	// Compare orders two participants within the same band.
+	Compare(a, b messages.RollResult) int
+}
+
+// NewOrdering returns the built-in ordering called name, defaulting to
+// highest first.
+func NewOrdering(name string) (Ordering, error) {
+	switch name {
+	case "", OrderHighestFirst:
+		return highestFirst{}, nil
+	case OrderLowestFirst:
+		return lowestFirst{}, nil
+	case OrderJoin:
+		return joinOrder{}, nil
+	case OrderAlphabetical:
+		return alphabetical{}, nil
+	case OrderShuffle:
+		return shuffled{seed: rand.Uint64()}, nil
+	case OrderDoneLast:
+		return doneLastOrder{}, nil
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnknownOrdering, name)
+	}
+}
+
+// doneFirst puts those who have finished at the top, then everyone still
+// waiting, then those who deferred to the end. Latecomers queued for the next
+// round and absentees come last.
+type doneFirst struct{}
+
+func (doneFirst) Rank(rr messages.RollResult) int {
+	switch rr.Status {
+	case messages.StatusDone, messages.StatusPassed:
+		return 0
+	case messages.StatusWaiting:
+		return 1
+	case messages.StatusDeferred:
+		return 2
+	case messages.StatusQueued:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// doneLast sinks those who have finished below everyone still to go.
+type doneLast struct{}
+
+func (doneLast) Rank(rr messages.RollResult) int {
+	switch rr.Status {
+	case messages.StatusWaiting:
+		return 0
+	case messages.StatusDeferred:
+		return 1
+	case messages.StatusDone, messages.StatusPassed:
+		return 2
+	case messages.StatusQueued:
+		return 3
+	default:
+		return 4
+	}
+}
+
+type highestFirst struct{ doneFirst }
+
+func (highestFirst) Name() string { return OrderHighestFirst }
+
+func (highestFirst) Compare(a, b messages.RollResult) int {
+	return cmp.Compare(b.Result, a.Result)
+}
+
+type lowestFirst struct{ doneFirst }
+
+func (lowestFirst) Name() string { return OrderLowestFirst }
+
+func (lowestFirst) Compare(a, b messages.RollResult) int {
+	return cmp.Compare(a.Result, b.Result)
+}
+
+type joinOrder struct{ doneFirst }
+
+func (joinOrder) Name() string { return OrderJoin }
+
+func (joinOrder) Compare(a, b messages.RollResult) int {
+	return cmp.Compare(a.ID, b.ID)
+}
+
+type alphabetical struct{ doneFirst }
+
+func (alphabetical) Name() string { return OrderAlphabetical }
+
+func (alphabetical) Compare(a, b messages.RollResult) int {
+	return cmp.Compare(a.User, b.User)
+}
+
+// shuffled orders participants randomly. Each user's place is derived from
+// the room's seed so the order holds still between updates.
+type shuffled struct {
+	doneFirst
+	seed uint64
+}
+
+func (shuffled) Name() string { return OrderShuffle }
+
+func (s shuffled) Compare(a, b messages.RollResult) int {
+	return cmp.Compare(s.key(a.User), s.key(b.User))
+}
+
+func (s shuffled) key(user string) uint64 {
+	h := fnv.New64a()
+	_ = binary.Write(h, binary.LittleEndian, s.seed)
+	_, _ = h.Write([]byte(user))
+	return h.Sum64()
+}
+
+type doneLastOrder struct{ doneLast }
+
+func (doneLastOrder) Name() string { return OrderDoneLast }
+
+func (doneLastOrder) Compare(a, b messages.RollResult) int {
+	return cmp.Compare(b.Result, a.Result)
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/abennett/ttt/pkg"
+	"github.com/abennett/ttt/pkg/messages"
+)
+
+var ErrUnknownPhase = errors.New("no such phase")
+
+// roomBase is what a room was created with. Each phase of an agenda starts
+// from it, changing only what the phase sets.
+type roomBase struct {
+	mode    Mode
+	dice    pkg.DiceRoll
+	timebox time.Duration
+}
+
+// with works out the settings for phase p.
+func (b roomBase) with(p messages.Phase) (roomBase, error) {
+	var err error
+	if p.Mode != "" {
+		if b.mode, err = ParseMode(p.Mode); err != nil {
+			return b, fmt.Errorf("phase %s: %w", p.Name, err)
+		}
+	}
+	if p.Dice != "" {
+		if b.dice, err = parseDice(p.Dice); err != nil {
+			return b, fmt.Errorf("phase %s: %w", p.Name, err)
+		}
+	}
+	if p.Timebox != "" {
+		if b.timebox, err = time.ParseDuration(p.Timebox); err != nil || b.timebox < 0 {
+			return b, fmt.Errorf("phase %s: invalid timebox %q", p.Name, p.Timebox)
+		}
+	}
+	return b, nil
+}
+
+// phase writes the base settings out as a phase, for snapshots.
+func (b roomBase) phase() messages.Phase {
+	return messages.Phase{
+		Mode:    string(b.mode),
+		Dice:    b.dice.String(),
+		Timebox: b.timebox.String(),
+	}
+}
+
+// enterPhase switches the room to the settings of the agenda's phase at
+// idx. The caller must hold r.mu.
+func (r *Room) enterPhase(idx int) error {
+	if idx < 0 || idx >= len(r.Agenda) {
+		return fmt.Errorf("%w %d", ErrUnknownPhase, idx)
+	}
+	settings, err := r.base.with(r.Agenda[idx])
+	if err != nil {
+		return err
+	}
+	r.Phase = idx
+	r.Mode = settings.mode
+	r.Dice = settings.dice
+	r.Timebox = settings.timebox
+	return nil
+}
+
+// changePhase moves the room to another phase of its agenda, or restarts
+// the current one, and starts a new round. The caller must hold r.mu.
+func (r *Room) changePhase(req messages.PhaseRequest) error {
+	if req.User != r.Host {
+		return fmt.Errorf("%w: %q cannot change phase", ErrNotHost, req.User)
+	}
+	if err := r.enterPhase(req.Index); err != nil {
+		return err
+	}
+	r.newRound()
+	return nil
+}
+
+// newRound rolls everyone again with the room's dice and puts them back to
+// waiting, apart from absentees. Latecomers queued for the next round are
+// let in. The caller must hold r.mu.
+func (r *Room) newRound() {
+	for _, user := range slices.Sorted(maps.Keys(r.Rolls)) {
+		roll := r.Rolls[user]
+		roll.Result = r.Dice.RollWith(r.rng)
+		roll.RollOff = 0
+		roll.Late = false
+		roll.Vote = ""
+		if roll.Status != messages.StatusAbsent {
+			roll.Status = messages.StatusWaiting
+		}
+	}
+	if r.TieBreak == TieBreakRollOff {
+		r.rollOff()
+	}
+	r.Revealed = false
+	r.manualOrder = nil
+	// The first speaker's turn starts afresh, even if it is the same person
+	r.speaker = ""
+}
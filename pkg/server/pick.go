@@ -0,0 +1,110 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abennett/ttt/pkg/messages"
+)
+
+var ErrNotEnoughPresent = errors.New("not enough people present")
+
+// pickHistory counts how often each user has been picked, per room name, so
+// that it outlives the rooms themselves.
+type pickHistory struct {
+	mu     sync.Mutex
+	counts map[string]map[string]int
+}
+
+func newPickHistory() *pickHistory {
+	return &pickHistory{counts: make(map[string]map[string]int)}
+}
+
+func (h *pickHistory) get(room string) map[string]int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return maps.Clone(h.counts[room])
+}
+
+func (h *pickHistory) record(room string, users []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	counts, ok := h.counts[room]
+	if !ok {
+		counts = make(map[string]int)
+		h.counts[room] = counts
+	}
+	for _, user := range users {
+		counts[user]++
+	}
+}
+
+// PickHistory returns how many times each user has been picked in rooms
+// called room.
+func (s *Server) PickHistory(room string) map[string]int {
+	return s.picks.get(room)
+}
+
+// pick chooses participants at random, favouring those picked least often
+// before: each is weighted 1/(1+times picked). The choice is recorded in the
+// room name's history. The caller must hold r.mu.
+func (r *Room) pick(req messages.PickRequest) (messages.Pick, error) {
+	if req.User != r.Host {
+		return messages.Pick{}, fmt.Errorf("%w: %q cannot pick", ErrNotHost, req.User)
+	}
+	count := max(req.Count, 1)
+	// Only those connected right now: anyone who left, for good or while
+	// reconnecting, keeps their row but can't take a role.
+	var present []string
+	for user, roll := range r.Rolls {
+		if r.isConnected(user) && roll.Status != messages.StatusAbsent {
+			present = append(present, user)
+		}
+	}
+	if count > len(present) {
+		return messages.Pick{}, fmt.Errorf("%w to pick %d", ErrNotEnoughPresent, count)
+	}
+	// Sorted so the draw only depends on the random source
+	slices.Sort(present)
+
+	history := r.picks.get(r.Name)
+	weights := make([]float64, len(present))
+	for idx, user := range present {
+		weights[idx] = 1 / float64(1+history[user])
+	}
+	chosen := make([]string, 0, count)
+	for range count {
+		idx := weightedIndex(weights)
+		chosen = append(chosen, present[idx])
+		present = slices.Delete(present, idx, idx+1)
+		weights = slices.Delete(weights, idx, idx+1)
+	}
+	r.picks.record(r.Name, chosen)
+	return messages.Pick{
+		Role:  strings.TrimSpace(req.Role),
+		Users: chosen,
+		At:    time.Now(),
+	}, nil
+}
+
+// weightedIndex draws an index with probability proportional to its weight.
+func weightedIndex(weights []float64) int {
+	var total float64
+	for _, w := range weights {
+		total += w
+	}
+	n := rand.Float64() * total
+	for idx, w := range weights {
+		if n < w {
+			return idx
+		}
+		n -= w
+	}
+	return len(weights) - 1
+}
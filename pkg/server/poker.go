@@ -0,0 +1,149 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/abennett/ttt/pkg/messages"
+)
+
+var (
+	ErrUnknownMode = errors.New("unknown room mode")
+	ErrUnknownDeck = errors.New("unknown deck")
+	ErrNotPoker    = errors.New("room is not in poker mode")
+	ErrRevealed    = errors.New("votes are already revealed")
+)
+
+// Mode is what a room is used for.
+type Mode string
+
+const (
+	// ModeRoll orders participants by their initiative roll.
+	ModeRoll Mode = "roll"
+	// ModePoker collects hidden estimates until the host reveals them.
+	ModePoker Mode = "poker"
+)
+
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(s); m {
+	case "":
+		return ModeRoll, nil
+	case ModeRoll, ModePoker:
+		return m, nil
+	default:
+		return "", fmt.Errorf("%w %q", ErrUnknownMode, s)
+	}
+}
+
+// Deck is the set of cards a planning poker estimate is chosen from.
+type Deck string
+
+const (
+	DeckFibonacci Deck = "fibonacci"
+	DeckTShirt    Deck = "tshirt"
+)
+
+// unsure is the card for someone who can't estimate.
+const unsure = "?"
+
+var decks = map[Deck][]string{
+	DeckFibonacci: {"0", "1", "2", "3", "5", "8", "13", "21", unsure},
+	DeckTShirt:    {"XS", "S", "M", "L", "XL", unsure},
+}
+
+func ParseDeck(s string) (Deck, error) {
+	if s == "" {
+		return DeckFibonacci, nil
+	}
+	d := Deck(s)
+	if _, ok := decks[d]; !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownDeck, s)
+	}
+	return d, nil
+}
+
+// Cards lists the deck's cards from smallest to largest.
+func (d Deck) Cards() []string {
+	return decks[d]
+}
+
+// vote records or withdraws an estimate. The caller must hold r.mu.
+func (r *Room) vote(req messages.VoteRequest) error {
+	if r.Mode != ModePoker {
+		return ErrNotPoker
+	}
+	if r.Revealed {
+		return ErrRevealed
+	}
+	user, ok := r.Rolls[req.User]
+	if !ok {
+		return fmt.Errorf("user %q does not exist", req.User)
+	}
+	if req.Card != "" && !slices.Contains(r.Deck.Cards(), req.Card) {
+		return fmt.Errorf("%q is not in the %s deck", req.Card, r.Deck)
+	}
+	user.Vote = req.Card
+	return nil
+}
+
+// reveal turns the votes over or clears them for the next estimate.
+// The caller must hold r.mu.
+func (r *Room) reveal(req messages.RevealRequest) error {
+	if r.Mode != ModePoker {
+		return ErrNotPoker
+	}
+	if req.User != r.Host {
+		return fmt.Errorf("%w: %q cannot reveal votes", ErrNotHost, req.User)
+	}
+	r.Revealed = req.Reveal
+	if !req.Reveal {
+		for _, roll := range r.Rolls {
+			roll.Vote = ""
+		}
+	}
+	return nil
+}
+
+// hideVotes keeps estimates secret until they are revealed, leaving only
+// whether each participant has voted.
+func (r *Room) hideVotes(rolls []messages.RollResult) {
+	for idx := range rolls {
+		rolls[idx].Voted = rolls[idx].Vote != ""
+		if !r.Revealed {
+			rolls[idx].Vote = ""
+		}
+	}
+}
+
+// estimate summarises the revealed votes.
+func (r *Room) estimate(rolls []messages.RollResult) *messages.Estimate {
+	cards := r.Deck.Cards()
+	var positions []int
+	var sum float64
+	numeric := true
+	for _, roll := range rolls {
+		if roll.Vote == "" || roll.Vote == unsure {
+			continue
+		}
+		positions = append(positions, slices.Index(cards, roll.Vote))
+		n, err := strconv.ParseFloat(roll.Vote, 64)
+		if err != nil {
+			numeric = false
+		}
+		sum += n
+	}
+	est := &messages.Estimate{Votes: len(positions)}
+	if len(positions) == 0 {
+		return est
+	}
+	slices.Sort(positions)
+	est.Median = cards[positions[(len(positions)-1)/2]]
+	est.Consensus = positions[0] == positions[len(positions)-1]
+	if numeric {
+		avg := sum / float64(len(positions))
+		est.Average = &avg
+	}
+	return est
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/abennett/ttt/pkg/messages"
+)
+
+// MaxPollOptions is the most choices a poll can offer.
+const MaxPollOptions = 9
+
+var (
+	ErrNoPoll        = errors.New("no poll is open")
+	ErrAlreadyVoted  = errors.New("already voted")
+	ErrInvalidPoll   = errors.New("invalid poll")
+	ErrUnknownOption = errors.New("unknown poll option")
+)
+
+// fistOfFive are the options of a fist-of-five vote.
+var fistOfFive = []string{"1", "2", "3", "4", "5"}
+
+type poll struct {
+	id         int
+	question   string
+	options    []string
+	fistOfFive bool
+	anonymous  bool
+	// votes maps each voter to the option they chose.
+	votes map[string]int
+}
+
+// openPoll replaces the room's poll, or closes it when the request has no
+// question. The caller must hold r.mu.
+func (r *Room) openPoll(req messages.PollRequest) error {
+	if req.User != r.Host {
+		return fmt.Errorf("%w: %q cannot run a poll", ErrNotHost, req.User)
+	}
+	question := strings.TrimSpace(req.Question)
+	if question == "" {
+		r.poll = nil
+		return nil
+	}
+	options := fistOfFive
+	if !req.FistOfFive {
+		options = make([]string, 0, len(req.Options))
+		for _, opt := range req.Options {
+			if opt = strings.TrimSpace(opt); opt != "" {
+				options = append(options, opt)
+			}
+		}
+		if len(options) < 2 || len(options) > MaxPollOptions {
+			return fmt.Errorf("%w: needs 2 to %d options", ErrInvalidPoll, MaxPollOptions)
+		}
+	}
+	r.pollCounter++
+	r.poll = &poll{
+		id:         r.pollCounter,
+		question:   question,
+		options:    options,
+		fistOfFive: req.FistOfFive,
+		anonymous:  req.Anonymous,
+		votes:      make(map[string]int),
+	}
+	return nil
+}
+
+// votePoll records a participant's one vote. The caller must hold r.mu.
+func (r *Room) votePoll(vote messages.PollVote) error {
+	if r.poll == nil {
+		return ErrNoPoll
+	}
+	if _, ok := r.Rolls[vote.User]; !ok {
+		return fmt.Errorf("user %q does not exist", vote.User)
+	}
+	if _, ok := r.poll.votes[vote.User]; ok {
+		return fmt.Errorf("%w: %q", ErrAlreadyVoted, vote.User)
+	}
+	if vote.Option < 0 || vote.Option >= len(r.poll.options) {
+		return fmt.Errorf("%w %d", ErrUnknownOption, vote.Option)
+	}
+	r.poll.votes[vote.User] = vote.Option
+	return nil
+}
+
+// toPoll tallies the poll for the room state.
+func (p *poll) toPoll() *messages.Poll {
+	if p == nil {
+		return nil
+	}
+	out := &messages.Poll{
+		ID:         p.id,
+		Question:   p.question,
+		Options:    slices.Clone(p.options),
+		FistOfFive: p.fistOfFive,
+		Anonymous:  p.anonymous,
+		Tallies:    make([]int, len(p.options)),
+		Voters:     slices.Sorted(maps.Keys(p.votes)),
+	}
+	if !p.anonymous {
+		out.Choices = make([][]string, len(p.options))
+	}
+	for _, voter := range out.Voters {
+		option := p.votes[voter]
+		out.Tallies[option]++
+		if out.Choices != nil {
+			out.Choices[option] = append(out.Choices[option], voter)
+		}
+	}
+	return out
+}
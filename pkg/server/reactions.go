@@ -0,0 +1,61 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/abennett/ttt/pkg/messages"
+)
+
+const (
+	// ReactionLimit is how many reactions a user may send in ReactionWindow.
+	ReactionLimit  = 5
+	ReactionWindow = 10 * time.Second
+)
+
+var (
+	ErrUnknownReaction = errors.New("unknown reaction")
+	ErrRateLimited     = errors.New("too many reactions, slow down")
+)
+
+// checkReaction makes sure a reaction is valid and the sender is within
+// their rate limit, counting it against that limit. The caller must hold r.mu.
+func (r *Room) checkReaction(req messages.ReactionRequest) error {
+	if !slices.Contains(messages.Reactions, req.Emoji) {
+		return fmt.Errorf("%w %q", ErrUnknownReaction, req.Emoji)
+	}
+	if _, ok := r.Rolls[req.User]; !ok {
+		return fmt.Errorf("user %q does not exist", req.User)
+	}
+	if _, ok := r.Rolls[req.Target]; !ok {
+		return fmt.Errorf("user %q does not exist", req.Target)
+	}
+	if req.Target == req.User {
+		return errors.New("reactions are for other people")
+	}
+
+	now := time.Now()
+	recent := slices.DeleteFunc(r.reactionTimes[req.User], func(at time.Time) bool {
+		return now.Sub(at) >= ReactionWindow
+	})
+	if len(recent) >= ReactionLimit {
+		r.reactionTimes[req.User] = recent
+		return fmt.Errorf("%w: %q", ErrRateLimited, req.User)
+	}
+	r.reactionTimes[req.User] = append(recent, now)
+	return nil
+}
+
+// react counts a reaction against its target. The caller must hold r.mu.
+func (r *Room) react(req messages.ReactionRequest) {
+	target, ok := r.Rolls[req.Target]
+	if !ok {
+		return
+	}
+	if target.Reactions == nil {
+		target.Reactions = make(map[string]int)
+	}
+	target.Reactions[req.Emoji]++
+}
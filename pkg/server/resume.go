@@ -0,0 +1,102 @@
+package server
+
+import (
+	"crypto/rand"
+	"slices"
+	"time"
+
+	"github.com/abennett/ttt/pkg/messages"
+)
+
+// expired removes a disconnected participant whose grace period ran out.
+type expired struct {
+	User string
+}
+
+// resumeToken returns the token user can reclaim their place with, minting
+// one if needed. The caller must hold r.mu.
+func (r *Room) resumeToken(user string) string {
+	token, ok := r.resumeTokens[user]
+	if !ok {
+		token = rand.Text()
+		r.resumeTokens[user] = token
+	}
+	return token
+}
+
+// resumer returns the participant holding token, if any.
+// The caller must hold r.mu.
+func (r *Room) resumer(token string) (string, bool) {
+	if token == "" {
+		return "", false
+	}
+	for user, t := range r.resumeTokens {
+		if t == token {
+			_, ok := r.Rolls[user]
+			return user, ok
+		}
+	}
+	return "", false
+}
+
+// holdPlace keeps a dropped participant's roll and status for the grace
+// period, after which they are removed. The caller must hold r.mu.
+func (r *Room) holdPlace(user string) {
+	r.holdPlaceFor(user, r.gracePeriod)
+}
+
+// holdPlaceFor is holdPlace for a grace period other than the room's.
+// The caller must hold r.mu.
+func (r *Room) holdPlaceFor(user string, grace time.Duration) {
+	roll, ok := r.Rolls[user]
+	if !ok || grace <= 0 {
+		return
+	}
+	roll.Disconnected = true
+	if r.replaying {
+		return
+	}
+	r.graceTimers[user] = time.AfterFunc(grace, func() {
+		if err := r.Update(expired{User: user}); err != nil {
+			r.logger.Error("failed expiring user", "user", user, "error", err)
+		}
+	})
+	r.logger.Info("holding place", "user", user, "grace_period", grace)
+}
+
+// reclaim gives a participant back the place held since they dropped.
+// The caller must hold r.mu.
+func (r *Room) reclaim(roll *messages.RollResult) {
+	if timer, ok := r.graceTimers[roll.User]; ok {
+		timer.Stop()
+		delete(r.graceTimers, roll.User)
+	}
+	roll.Disconnected = false
+}
+
+// removeUser drops a participant from the room entirely.
+// The caller must hold r.mu.
+func (r *Room) removeUser(user string) {
+	delete(r.Rolls, user)
+	delete(r.resumeTokens, user)
+	delete(r.graceTimers, user)
+	delete(r.reactionTimes, user)
+	if idx := slices.Index(r.manualOrder, user); idx >= 0 {
+		r.manualOrder = slices.Delete(r.manualOrder, idx, idx+1)
+	}
+}
+
+// closeIfEmpty closes the room once no one is connected and no places are
+// being held, unless it is a scheduled room whose slot is still going.
+// The caller must hold r.mu.
+func (r *Room) closeIfEmpty() {
+	if len(r.userSessions) > 0 || len(r.graceTimers) > 0 {
+		return
+	}
+	if time.Now().Before(r.closesAt) {
+		return
+	}
+	if r.onEmpty != nil {
+		r.onEmpty()
+	}
+}
@@ -3,10 +3,14 @@ package server
 import (
 	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"math/rand/v2"
 	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -21,22 +25,102 @@ const (
 )
 
 type userSession struct {
-	wg      *sync.WaitGroup
-	logger  *slog.Logger
-	name    string
-	writeCh chan []byte
+	id     uint64
+	wg     *sync.WaitGroup
+	logger *slog.Logger
+	name   string
+	// spectator sessions watch the room without a place in it.
+	spectator bool
+	writeCh   chan []byte
+	done      <-chan struct{}
+	// closeCh carries the reason the room is closing.
+	closeCh chan string
+	// left is set when the user closed the connection on purpose.
+	left *atomic.Bool
 }
 
 type Room struct {
-	mu           *sync.Mutex
-	logger       *slog.Logger
-	userSessions map[string]userSession
-	userCounter  uint32
-
-	Version int
-	Name    string
-	Dice    pkg.DiceRoll
-	Rolls   map[string]*messages.RollResult
+	mu             *sync.Mutex
+	logger         *slog.Logger
+	userSessions   map[uint64]userSession
+	sessionCounter uint64
+	userCounter    uint32
+	duplicates     DuplicatePolicy
+	gracePeriod    time.Duration
+	// resumeTokens and graceTimers are keyed by username.
+	resumeTokens map[string]string
+	graceTimers  map[string]*time.Timer
+	// onEmpty is called, with mu held, once the room has no one left in it.
+	onEmpty func()
+
+	Version  int
+	Name     string
+	Host     string
+	Dice     pkg.DiceRoll
+	Ordering Ordering
+	TieBreak TieBreak
+	LateJoin LateJoin
+	Teams    TeamOrder
+	Capacity int
+	Locked   bool
+	Mode     Mode
+	Deck     Deck
+	// Timebox is how long each turn should take, zero for no limit.
+	Timebox time.Duration
+	// Agenda is the meeting's phases and Phase the current one's index.
+	// Mode, Dice and Timebox follow the phase, starting from base.
+	Agenda []messages.Phase
+	Phase  int
+	base   roomBase
+	// Revealed is set while planning poker votes are face up.
+	Revealed bool
+	Rolls    map[string]*messages.RollResult
+	// Roster is who the room expects to join; absentees are those on it
+	// the host has marked absent before they joined.
+	Roster    []string
+	absentees map[string]bool
+
+	// manualOrder overrides Ordering once the host has moved someone.
+	manualOrder []string
+	chat        []messages.ChatMessage
+	poll        *poll
+	pollCounter int
+	// picks is shared by every room of the same name; lastPick is the most
+	// recent result.
+	picks    *pickHistory
+	lastPick *messages.Pick
+	// reactionTimes holds when each user recently reacted, for rate limiting.
+	reactionTimes map[string][]time.Time
+	// watching is the spectator count last announced.
+	watching int
+	// rng makes every random choice while applying updates, so a replay
+	// seeded the same way makes the same choices.
+	rng  *rand.Rand
+	seed uint64
+	// events records every update applied, if the server keeps logs.
+	events EventLog
+	// replaying is set on rooms rebuilt from a log, which have no sessions
+	// and start no timers.
+	replaying bool
+	// createdAt and finishedAt, when each participant finished their turn,
+	// are kept for the session's stats.
+	createdAt  time.Time
+	finishedAt map[string]time.Time
+	// speaker is whoever's turn it is, since turnStarted.
+	speaker     string
+	turnStarted time.Time
+	// lastActive is when the last update was applied.
+	lastActive time.Time
+	// closesAt is when a scheduled room's slot ends. It stays open until
+	// then, even with no one in it.
+	closesAt time.Time
+	// closed is set once the room has been shut and takes no more updates.
+	closed bool
+	// store is where the room is saved after every update, if anywhere.
+	store RoomStore
+	// secret is the password or join code needed to enter the room.
+	secret   string
+	joinCode string
 }
 
 func (r *Room) RunSession(ctx context.Context, conn *websocket.Conn) {
@@ -52,27 +136,41 @@ func (r *Room) RunSession(ctx context.Context, conn *websocket.Conn) {
 		return
 	}
 
-	req, ok := msg.Payload.(messages.RollRequest)
-	if !ok {
-		r.logger.Error("initial message was incorrect", "error", err, "payload", string(b))
-		return
+	switch req := msg.Payload.(type) {
+	case messages.RollRequest:
+		r.runParticipant(ctx, req, conn)
+	case messages.WatchRequest:
+		r.runSpectator(ctx, req, conn)
+	default:
+		r.logger.Error("initial message was incorrect", "type", msg.Type, "payload", string(b))
 	}
+}
 
-	name := req.User
-	r.logger.Debug("starting a session", "user", name)
-	writeCh := make(chan []byte, 1)
-	session := userSession{
-		wg:      new(sync.WaitGroup),
-		logger:  slog.With("user", req.User),
-		name:    req.User,
-		writeCh: writeCh,
+// runParticipant rolls for a user joining the turn order and keeps their
+// session going until it ends.
+func (r *Room) runParticipant(ctx context.Context, req messages.RollRequest, conn *websocket.Conn) {
+	r.logger.Debug("starting a session", "user", req.User)
+	session, err := r.startUserSession(ctx, req, conn)
+	if err != nil {
+		r.logger.Info("refused session", "user", req.User, "error", err)
+		code := messages.ErrCodeDuplicateUser
+		switch {
+		case errors.Is(err, ErrRoomClosed):
+			code = messages.ErrCodeRoomClosed
+		case errors.Is(err, ErrRoomFull), errors.Is(err, ErrRoomLocked):
+			code = messages.ErrCodeRoomFull
+		}
+		refuse(conn, code, err)
+		return
 	}
 
-	r.startUserSession(ctx, session, conn)
-
+	// The roll itself is made under the lock, with the dice of the phase the
+	// room is in by then
 	roll := messages.RollResult{
-		User:   name,
-		Result: r.Dice.Roll(),
+		User:     session.name,
+		Modifier: req.Modifier,
+		Team:     strings.TrimSpace(req.Team),
+		JoinedAt: time.Now(),
 	}
 
 	err = r.Update(roll)
@@ -83,25 +181,155 @@ func (r *Room) RunSession(ctx context.Context, conn *websocket.Conn) {
 
 	session.wg.Wait()
 	r.stopUserSession(session)
-	r.logger.Info("closing session", "active_sessions", len(r.userSessions), "user", name)
 }
 
-func (r *Room) startUserSession(ctx context.Context, session userSession, conn *websocket.Conn) {
+// runSpectator keeps a spectator's session going until it ends. Spectators
+// receive every update but have no place in the turn order.
+func (r *Room) runSpectator(ctx context.Context, req messages.WatchRequest, conn *websocket.Conn) {
+	r.logger.Debug("starting a spectator session", "spectator", req.Name)
 	r.mu.Lock()
-	r.userSessions[session.name] = session
+	if r.closed {
+		r.mu.Unlock()
+		refuse(conn, messages.ErrCodeRoomClosed, ErrRoomClosed)
+		return
+	}
+	session := r.addSession(ctx, "", true, conn)
+	err := r.update(spectatorsChanged{Count: r.spectators()})
 	r.mu.Unlock()
+	if err != nil {
+		r.logger.Error(err.Error())
+	}
 
-	// Add to the waitGroup outside of goroutines here to avoid race condition on Add
+	session.wg.Wait()
+	r.stopUserSession(session)
+}
+
+// refuse tells a client why it cannot join before closing the connection.
+func refuse(conn *websocket.Conn, code string, reason error) {
+	b, err := msgpack.Marshal(messages.Message{
+		Type:    messages.ErrorMsgType,
+		Version: "1",
+		Payload: messages.ErrorMessage{
+			Code:    code,
+			Message: reason.Error(),
+		},
+	})
+	if err == nil {
+		_ = conn.WriteMessage(websocket.BinaryMessage, b)
+	}
+	_ = conn.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.ClosePolicyViolation, code),
+		time.Now().Add(time.Second),
+	)
+}
+
+// startUserSession registers a session under the name the requester is
+// allowed to use in the room and starts its read and write loops. A valid
+// resume token reclaims the place it was issued for.
+func (r *Room) startUserSession(ctx context.Context, req messages.RollRequest, conn *websocket.Conn) (userSession, error) {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return userSession{}, ErrRoomClosed
+	}
+	name, ok := r.resumer(req.ResumeToken)
+	if ok {
+		r.logger.Info("resuming session", "user", name)
+	} else {
+		// Checked again now the room can't change, as the handshake may
+		// have carried a different token or claimed to be a spectator's
+		if err := r.checkCapacity(); err != nil {
+			r.mu.Unlock()
+			return userSession{}, err
+		}
+		var err error
+		name, err = r.claimName(req.User)
+		if err != nil {
+			r.mu.Unlock()
+			return userSession{}, err
+		}
+	}
+	session := r.addSession(ctx, name, false, conn)
+	r.mu.Unlock()
+	return session, nil
+}
+
+// addSession registers a session and starts its read and write loops.
+// The caller must hold r.mu.
+func (r *Room) addSession(ctx context.Context, name string, spectator bool, conn *websocket.Conn) userSession {
 	ctx, cancel := context.WithCancel(ctx)
+	r.sessionCounter++
+	logger := slog.With("user", name)
+	if spectator {
+		logger = slog.With("spectator", r.sessionCounter)
+	}
+	session := userSession{
+		id:        r.sessionCounter,
+		wg:        new(sync.WaitGroup),
+		logger:    logger,
+		name:      name,
+		spectator: spectator,
+		writeCh:   make(chan []byte, 1),
+		closeCh:   make(chan string, 1),
+		done:      ctx.Done(),
+		left:      new(atomic.Bool),
+	}
+	r.userSessions[session.id] = session
+
+	// Add to the waitGroup outside of goroutines here to avoid race condition on Add
 	session.wg.Add(2)
 	go r.userReadLoop(cancel, session, conn)
 	go r.userWriteLoop(ctx, session, conn)
+	return session
 }
 
 func (r *Room) stopUserSession(session userSession) {
 	r.mu.Lock()
-	delete(r.userSessions, session.name)
-	r.mu.Unlock()
+	defer r.mu.Unlock()
+	delete(r.userSessions, session.id)
+	r.logger.Info("closing session", "active_sessions", len(r.userSessions), "user", session.name)
+	if session.spectator {
+		if err := r.update(spectatorsChanged{Count: r.spectators()}); err != nil {
+			r.logger.Error("failed updating spectators", "error", err)
+		}
+		r.closeIfEmpty()
+		return
+	}
+
+	host := r.Host
+	if session.name == r.Host {
+		host = r.nextHost()
+	}
+	err := r.update(left{
+		User:    session.name,
+		Host:    host,
+		Dropped: !session.left.Load() && !r.isConnected(session.name),
+	})
+	if err != nil {
+		r.logger.Error("failed leaving", "user", session.name, "error", err)
+	}
+	r.closeIfEmpty()
+}
+
+// nextHost picks who takes over as host: the earliest joiner still
+// connected. The caller must hold r.mu.
+func (r *Room) nextHost() string {
+	var (
+		host   string
+		hostID uint32
+	)
+	for _, session := range r.userSessions {
+		roll, ok := r.Rolls[session.name]
+		if !ok {
+			continue
+		}
+		if host == "" || roll.ID < hostID {
+			host = session.name
+			hostID = roll.ID
+		}
+	}
+	return host
 }
 
 func (r *Room) userReadLoop(cancel func(), session userSession, conn *websocket.Conn) {
@@ -114,6 +342,7 @@ func (r *Room) userReadLoop(cancel func(), session userSession, conn *websocket.
 		if closeErr, ok := err.(*websocket.CloseError); ok {
 			if closeErr.Code == websocket.CloseNormalClosure {
 				session.logger.Info("close message received")
+				session.left.Store(true)
 				return
 			}
 		}
@@ -125,8 +354,13 @@ func (r *Room) userReadLoop(cancel func(), session userSession, conn *websocket.
 		switch t {
 		case websocket.CloseMessage:
 			session.logger.Info("close message received")
+			session.left.Store(true)
 			return
 		case websocket.BinaryMessage:
+			if session.spectator {
+				session.logger.Debug("ignoring message from spectator")
+				continue
+			}
 			session.logger.Info("binary message received")
 			var msg messages.Message
 			err := msgpack.Unmarshal(b, &msg)
@@ -134,7 +368,7 @@ func (r *Room) userReadLoop(cancel func(), session userSession, conn *websocket.
 				r.logger.Error("failed handling binary message", "error", err)
 				return
 			}
-			err = r.Update(msg.Payload)
+			err = r.Update(attribute(msg.Payload, session.name))
 			if err != nil {
 				r.logger.Error("failed updating server", "error", err)
 			}
@@ -174,6 +408,12 @@ func (r *Room) userWriteLoop(ctx context.Context, session userSession, conn *web
 				r.logger.Error(err.Error())
 				return
 			}
+		case reason := <-session.closeCh:
+			session.logger.Info("room closed", "reason", reason)
+			refuse(conn, messages.ErrCodeRoomClosed, errors.New(reason))
+			// Give the client a moment to answer before dropping it
+			_ = conn.SetReadDeadline(time.Now().Add(closeTimeout))
+			return
 		case <-ticker.C:
 			session.logger.Debug("writing ping message")
 			err := conn.WriteMessage(websocket.PingMessage, []byte{})
@@ -192,50 +432,340 @@ func (r *Room) userWriteLoop(ctx context.Context, session userSession, conn *web
 func (r *Room) Update(update any) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	return r.update(update)
+}
 
+// update decides whether an update is accepted, applies it, records it and
+// pushes the new state out. The caller must hold r.mu.
+func (r *Room) update(update any) error {
+	if r.closed {
+		return nil
+	}
+	event, err := r.decide(update)
+	if err != nil || event == nil {
+		return err
+	}
+	if err := r.apply(event); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	r.Version++
+	r.lastActive = now
+	r.trackFinished()
+	r.trackTurn(now)
+	r.record(event, now)
+	r.persist()
+	err = r.broadcast()
+	if _, ok := event.(expired); ok {
+		r.closeIfEmpty()
+	}
+	return err
+}
+
+// decide settles everything about an update that depends on the world
+// outside the room, such as the time or the server's pick history, and
+// returns the event to apply. A nil event means there is nothing to do.
+// The caller must hold r.mu.
+func (r *Room) decide(update any) (any, error) {
 	switch u := update.(type) {
+	case messages.ChatMessage:
+		u.At = time.Now()
+		return u, nil
+	case messages.ReactionRequest:
+		if err := r.checkReaction(u); err != nil {
+			return nil, err
+		}
+		return u, nil
+	case messages.PickRequest:
+		p, err := r.pick(u)
+		if err != nil {
+			return nil, err
+		}
+		return picked{Pick: p}, nil
+	case expired:
+		if r.isConnected(u.User) {
+			return nil, nil
+		}
+		return u, nil
+	default:
+		return update, nil
+	}
+}
+
+// apply changes the room for an event. Given the same events and seed it
+// always ends in the same state, which is what lets a log be replayed.
+// The caller must hold r.mu.
+func (r *Room) apply(event any) error {
+	switch u := event.(type) {
 	case messages.RollResult:
-		_, ok := r.Rolls[u.User]
-		if !ok {
+		// Someone rejoining, or on a second device, keeps their roll
+		if existing, ok := r.Rolls[u.User]; ok {
+			r.reclaim(existing)
+		} else {
+			u.Result = r.Dice.RollWith(r.rng)
 			u.ID = r.userCounter
 			r.userCounter++
+			r.admit(&u)
+			r.Rolls[u.User] = &u
+			// Turning up after all overrides being marked absent
+			delete(r.absentees, u.User)
+		}
+		if r.Host == "" {
+			r.Host = u.User
+		}
+		if r.TieBreak == TieBreakRollOff {
+			r.rollOff()
 		}
-		r.Rolls[u.User] = &u
 		r.logger.Debug("added roll", "active_sessions", len(r.userSessions), "user", u.User)
 	case messages.DoneRequest:
 		user, ok := r.Rolls[u.User]
 		if !ok {
 			return fmt.Errorf("user %q does not exist", u.User)
 		}
-		user.IsDone = !user.IsDone
-		r.logger.Debug("user is done", "user", u.User)
+		if user.Status == messages.StatusQueued {
+			return fmt.Errorf("%w: %q", ErrQueued, u.User)
+		}
+		if user.Status == messages.StatusDone {
+			user.Status = messages.StatusWaiting
+		} else {
+			user.Status = messages.StatusDone
+		}
+		r.logger.Debug("user is done", "user", u.User, "status", user.Status)
+	case messages.StatusRequest:
+		if !settable(u.Status) {
+			return fmt.Errorf("%w: %s", ErrInvalidStatus, u.Status)
+		}
+		target := cmp.Or(u.Target, u.User)
+		user, ok := r.Rolls[target]
+		if !ok {
+			u.Target = target
+			if err := r.markMissing(u); err != nil {
+				return err
+			}
+			r.logger.Debug("missing user status changed", "user", target, "by", u.User, "status", u.Status)
+			break
+		}
+		if (target != u.User || u.Status == messages.StatusAbsent) && u.User != r.Host {
+			return fmt.Errorf("%w: %q cannot mark %q %s", ErrNotHost, u.User, target, u.Status)
+		}
+		if target == u.User && user.Status == messages.StatusQueued {
+			return fmt.Errorf("%w: %q", ErrQueued, u.User)
+		}
+		user.Status = u.Status
+		r.logger.Debug("user status changed", "user", target, "by", u.User, "status", u.Status)
+	case messages.MoveUserRequest:
+		if u.User != r.Host {
+			return fmt.Errorf("%w: %q cannot reorder the room", ErrNotHost, u.User)
+		}
+		if err := r.moveUser(u); err != nil {
+			return err
+		}
+		r.logger.Debug("user moved", "user", u.Target, "by", u.User, "action", u.Action, "index", u.Index)
+	case messages.LockRequest:
+		if u.User != r.Host {
+			return fmt.Errorf("%w: %q cannot lock the room", ErrNotHost, u.User)
+		}
+		r.Locked = u.Locked
+		r.logger.Debug("room lock changed", "by", u.User, "locked", u.Locked)
+	case messages.ChatMessage:
+		if err := r.addChat(u); err != nil {
+			return err
+		}
+		r.logger.Debug("chat message", "user", u.User)
+	case messages.ReactionRequest:
+		r.react(u)
+		r.logger.Debug("reaction", "user", u.User, "target", u.Target, "emoji", u.Emoji)
+	case messages.VoteRequest:
+		if err := r.vote(u); err != nil {
+			return err
+		}
+		r.logger.Debug("vote", "user", u.User)
+	case messages.RevealRequest:
+		if err := r.reveal(u); err != nil {
+			return err
+		}
+		r.logger.Debug("votes revealed", "by", u.User, "revealed", u.Reveal)
+	case messages.PollRequest:
+		if err := r.openPoll(u); err != nil {
+			return err
+		}
+		r.logger.Debug("poll changed", "by", u.User, "question", u.Question)
+	case messages.PollVote:
+		if err := r.votePoll(u); err != nil {
+			return err
+		}
+		r.logger.Debug("poll vote", "user", u.User)
+	case picked:
+		r.lastPick = &u.Pick
+		r.logger.Debug("picked", "role", u.Pick.Role, "users", u.Pick.Users)
+	case messages.NotesRequest:
+		if err := r.setNotes(u); err != nil {
+			return err
+		}
+		r.logger.Debug("notes updated", "user", u.User)
+	case messages.PhaseRequest:
+		if err := r.changePhase(u); err != nil {
+			return err
+		}
+		r.logger.Debug("phase changed", "by", u.User, "phase", u.Index)
+	case spectatorsChanged:
+		r.watching = u.Count
+		r.logger.Debug("spectators changed", "spectators", u.Count)
+	case left:
+		r.Host = u.Host
+		if u.Dropped {
+			r.holdPlace(u.User)
+		}
+		r.logger.Debug("user left", "user", u.User, "host", u.Host, "dropped", u.Dropped)
+	case expired:
+		r.removeUser(u.User)
+		r.logger.Info("grace period expired", "user", u.User)
 	default:
-		err := fmt.Errorf("unknown update type: %T", update)
+		err := fmt.Errorf("unknown update type: %T", event)
 		r.logger.Error(err.Error())
 		return err
 	}
+	r.letQueueIn()
+	return nil
+}
 
-	r.Version++
-
-	msg := messages.Message{
-		Type:    messages.StateMsgType,
-		Version: "1",
-		Payload: r.ToState(),
-	}
-	b, err := msgpack.Marshal(msg)
-	if err != nil {
-		r.logger.Error("failed marshalling room", "error", err)
-		return err
+// settable reports whether a participant's status can be set to s by
+// request. Queued is only ever set by the server.
+func settable(s messages.Status) bool {
+	switch s {
+	case messages.StatusWaiting, messages.StatusDone, messages.StatusPassed,
+		messages.StatusDeferred, messages.StatusAbsent:
+		return true
+	default:
+		return false
 	}
+}
 
+// broadcast pushes the room's state to every session, each addressed to the
+// name it is known by. The caller must hold r.mu.
+func (r *Room) broadcast() error {
+	state := r.ToState()
 	for _, us := range r.userSessions {
+		state.You, state.ResumeToken, state.YourVote = "", "", ""
+		if !us.spectator {
+			state.You = us.name
+			state.ResumeToken = r.resumeToken(us.name)
+			if roll, ok := r.Rolls[us.name]; ok {
+				state.YourVote = roll.Vote
+			}
+		}
+		b, err := msgpack.Marshal(messages.Message{
+			Type:    messages.StateMsgType,
+			Version: "1",
+			Payload: state,
+		})
+		if err != nil {
+			r.logger.Error("failed marshalling room", "error", err)
+			return err
+		}
 		r.logger.Debug("pushing update", "user", us.name, "version", r.Version)
-		us.writeCh <- b
+		select {
+		case us.writeCh <- b:
+		case <-us.done:
+		}
 	}
 	return nil
 }
 
-func (r *Room) ToState() messages.RoomState {
+// attribute stamps a request with the name of the session it arrived on, so
+// no one can act on behalf of someone else.
+func attribute(update any, user string) any {
+	switch u := update.(type) {
+	case messages.DoneRequest:
+		u.User = user
+		return u
+	case messages.StatusRequest:
+		u.User = user
+		return u
+	case messages.MoveUserRequest:
+		u.User = user
+		return u
+	case messages.LockRequest:
+		u.User = user
+		return u
+	case messages.ChatMessage:
+		u.User = user
+		return u
+	case messages.ReactionRequest:
+		u.User = user
+		return u
+	case messages.VoteRequest:
+		u.User = user
+		return u
+	case messages.RevealRequest:
+		u.User = user
+		return u
+	case messages.PollRequest:
+		u.User = user
+		return u
+	case messages.PollVote:
+		u.User = user
+		return u
+	case messages.PickRequest:
+		u.User = user
+		return u
+	case messages.NotesRequest:
+		u.User = user
+		return u
+	case messages.PhaseRequest:
+		u.User = user
+		return u
+	default:
+		return update
+	}
+}
+
+// moveUser rearranges the turn order by hand, starting from the order
+// currently shown. The caller must hold r.mu.
+func (r *Room) moveUser(move messages.MoveUserRequest) error {
+	if move.Action == messages.MoveReset {
+		r.manualOrder = nil
+		return nil
+	}
+
+	rolls := r.sortedRolls()
+	order := make([]string, len(rolls))
+	from := -1
+	for idx, roll := range rolls {
+		order[idx] = roll.User
+		if roll.User == move.Target {
+			from = idx
+		}
+	}
+	if from < 0 {
+		return fmt.Errorf("user %q does not exist", move.Target)
+	}
+
+	var to int
+	switch move.Action {
+	case messages.MoveToIndex:
+		to = move.Index
+	case messages.MoveUp:
+		to = from - 1
+	case messages.MoveDown:
+		to = from + 1
+	case messages.MoveToTop:
+		to = 0
+	default:
+		return fmt.Errorf("unknown move action: %d", move.Action)
+	}
+	to = max(0, min(to, len(order)-1))
+
+	order = slices.Delete(order, from, from+1)
+	r.manualOrder = slices.Insert(order, to, move.Target)
+	return nil
+}
+
+// sortedRolls returns the participants in turn order. Anyone the host has
+// placed by hand comes first, as arranged; everyone else follows in the
+// room's ordering. The caller must hold r.mu.
+func (r *Room) sortedRolls() []messages.RollResult {
 	rolls := make([]messages.RollResult, len(r.Rolls))
 	var i int
 	for _, roll := range r.Rolls {
@@ -244,23 +774,119 @@ func (r *Room) ToState() messages.RoomState {
 	}
 
 	slices.SortFunc(rolls, func(a, b messages.RollResult) int {
-		if a.IsDone && !b.IsDone {
-			return -1
-		}
-		if b.IsDone && !a.IsDone {
-			return 1
-		}
-		comparison := cmp.Compare(b.Result, a.Result)
-		if comparison == 0 {
-			return cmp.Compare(a.ID, b.ID)
-		}
-		return comparison
+		return cmp.Or(
+			cmp.Compare(r.Ordering.Rank(a), r.Ordering.Rank(b)),
+			r.LateJoin.Compare(a, b),
+			r.Ordering.Compare(a, b),
+			r.TieBreak.Compare(a, b),
+			cmp.Compare(a.ID, b.ID),
+		)
 	})
+	r.groupTeams(rolls)
 
+	if len(r.manualOrder) > 0 {
+		placed := make(map[string]int, len(r.manualOrder))
+		for idx, name := range r.manualOrder {
+			placed[name] = idx
+		}
+		slices.SortStableFunc(rolls, func(a, b messages.RollResult) int {
+			ai, aok := placed[a.User]
+			bi, bok := placed[b.User]
+			switch {
+			case aok && bok:
+				return cmp.Compare(ai, bi)
+			case aok:
+				return -1
+			case bok:
+				return 1
+			default:
+				return 0
+			}
+		})
+	}
+	return rolls
+}
+
+// State returns the room's current state as everyone in it sees it.
+func (r *Room) State() messages.RoomState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ToState()
+}
+
+// ToState is State for callers that already hold r.mu.
+func (r *Room) ToState() messages.RoomState {
+	rolls := r.sortedRolls()
+	var (
+		deck     []string
+		estimate *messages.Estimate
+	)
+	if r.Mode == ModePoker {
+		deck = r.Deck.Cards()
+		if r.Revealed {
+			estimate = r.estimate(rolls)
+		}
+		r.hideVotes(rolls)
+	}
 	return messages.RoomState{
-		Version: r.Version,
-		Name:    r.Name,
-		Dice:    r.Dice.String(),
-		Rolls:   rolls,
+		Version:     r.Version,
+		Name:        r.Name,
+		Host:        r.Host,
+		Dice:        r.Dice.String(),
+		Ordering:    r.Ordering.Name(),
+		TieBreak:    string(r.TieBreak),
+		LateJoin:    string(r.LateJoin),
+		Teams:       string(r.Teams),
+		Mode:        string(r.Mode),
+		Deck:        deck,
+		Revealed:    r.Revealed,
+		Estimate:    estimate,
+		Protected:   r.secret != "",
+		JoinCode:    r.joinCode,
+		Capacity:    r.Capacity,
+		Locked:      r.Locked,
+		Spectators:  r.watching,
+		Chat:        slices.Clone(r.chat),
+		Poll:        r.poll.toPoll(),
+		Pick:        r.lastPick,
+		ManualOrder: len(r.manualOrder) > 0,
+		Rolls:       rolls,
+		Roster:      slices.Clone(r.Roster),
+		NotJoined:   r.notJoined(),
+		ClosesAt:    r.closesAt,
+		Timebox:     r.Timebox,
+		Agenda:      slices.Clone(r.Agenda),
+		Phase:       r.Phase,
+		TurnStarted: r.turnStarted,
+	}
+}
+
+// spectatorsChanged pushes out a new spectator count.
+type spectatorsChanged struct {
+	Count int
+}
+
+// left is a participant's session ending, passing on the host role if it
+// was theirs. Dropped is set when the connection was lost rather than
+// closed, so their place is held for them.
+type left struct {
+	User    string
+	Host    string
+	Dropped bool
+}
+
+// picked is the outcome of a PickRequest.
+type picked struct {
+	Pick messages.Pick
+}
+
+// spectators counts the sessions watching the room. The caller must hold r.mu.
+func (r *Room) spectators() int {
+	var n int
+	for _, session := range r.userSessions {
+		if session.spectator {
+			n++
+		}
 	}
+	return n
 }
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/abennett/ttt/pkg/messages"
+)
+
+// markMissing lets the host mark someone on the roster who hasn't joined as
+// absent, or expected again. The caller must hold r.mu.
+func (r *Room) markMissing(req messages.StatusRequest) error {
+	if !slices.Contains(r.Roster, req.Target) {
+		return fmt.Errorf("user %q does not exist", req.Target)
+	}
+	if req.User != r.Host {
+		return fmt.Errorf("%w: %q cannot mark %q %s", ErrNotHost, req.User, req.Target, req.Status)
+	}
+	switch req.Status {
+	case messages.StatusAbsent:
+		r.absentees[req.Target] = true
+	case messages.StatusWaiting:
+		delete(r.absentees, req.Target)
+	default:
+		return fmt.Errorf("%q has not joined, so cannot be %s", req.Target, req.Status)
+	}
+	return nil
+}
+
+// notJoined lists everyone on the roster who hasn't joined yet, in roster
+// order. The caller must hold r.mu.
+func (r *Room) notJoined() []messages.RollResult {
+	var missing []messages.RollResult
+	for _, user := range r.Roster {
+		if _, ok := r.Rolls[user]; ok {
+			continue
+		}
+		status := messages.StatusWaiting
+		if r.absentees[user] {
+			status = messages.StatusAbsent
+		}
+		missing = append(missing, messages.RollResult{
+			User:   user,
+			Status: status,
+		})
+	}
+	return missing
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abennett/ttt/pkg/messages"
+)
+
+var ErrInvalidRecurrence = errors.New("invalid recurrence")
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Recurrence is a time of day on some days of the week, in local time.
+type Recurrence struct {
+	// Days is indexed by time.Weekday.
+	Days   [7]bool
+	Hour   int
+	Minute int
+}
+
+// ParseRecurrence reads a recurrence such as "weekdays 09:30", "daily
+// 17:00" or "mon,wed,fri 10:15". Days are "daily", "weekdays", "weekends"
+// or a comma separated list of three letter day names.
+func ParseRecurrence(s string) (Recurrence, error) {
+	var rc Recurrence
+	days, clock, ok := strings.Cut(strings.TrimSpace(s), " ")
+	if !ok {
+		return rc, fmt.Errorf("%w %q: want days and a time", ErrInvalidRecurrence, s)
+	}
+	switch days = strings.ToLower(days); days {
+	case "daily":
+		rc.Days = [7]bool{true, true, true, true, true, true, true}
+	case "weekdays":
+		rc.Days = [7]bool{false, true, true, true, true, true, false}
+	case "weekends":
+		rc.Days = [7]bool{true, false, false, false, false, false, true}
+	default:
+		for _, name := range strings.Split(days, ",") {
+			day, ok := weekdays[name]
+			if !ok {
+				return rc, fmt.Errorf("%w %q: unknown day %q", ErrInvalidRecurrence, s, name)
+			}
+			rc.Days[day] = true
+		}
+	}
+	hour, minute, ok := strings.Cut(strings.TrimSpace(clock), ":")
+	var err error
+	if ok {
+		rc.Hour, err = strconv.Atoi(hour)
+	}
+	if ok && err == nil {
+		rc.Minute, err = strconv.Atoi(minute)
+	}
+	if !ok || err != nil || rc.Hour < 0 || rc.Hour > 23 || rc.Minute < 0 || rc.Minute > 59 {
+		return rc, fmt.Errorf("%w %q: bad time %q", ErrInvalidRecurrence, s, clock)
+	}
+	return rc, nil
+}
+
+// Next returns the first occurrence strictly after t, in t's location.
+func (rc Recurrence) Next(t time.Time) time.Time {
+	y, m, d := t.Date()
+	for i := range 8 {
+		next := time.Date(y, m, d+i, rc.Hour, rc.Minute, 0, 0, t.Location())
+		if rc.Days[next.Weekday()] && next.After(t) {
+			return next
+		}
+	}
+	// No days set
+	return time.Time{}
+}
+
+// Schedule opens a room with the same options at the same time on some days
+// of the week, and closes it once Duration has passed.
+type Schedule struct {
+	Room     string
+	When     Recurrence
+	Duration time.Duration
+	Options  messages.RoomOptions
+}
+
+// scheduleConfig is how a Schedule is written in a schedule file.
+type scheduleConfig struct {
+	Room     string `json:"room"`
+	When     string `json:"when"`
+	Duration string `json:"duration"`
+	roomConfig
+}
+
+// LoadSchedules reads a JSON list of schedules from path, checking each one
+// so mistakes show up when the server starts rather than at meeting time.
+func LoadSchedules(path string) ([]Schedule, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs []scheduleConfig
+	if err := json.Unmarshal(b, &configs); err != nil {
+		return nil, fmt.Errorf("bad schedule file %s: %w", path, err)
+	}
+	schedules := make([]Schedule, 0, len(configs))
+	for _, c := range configs {
+		if c.Room == "" {
+			return nil, errors.New("scheduled room has no name")
+		}
+		when, err := ParseRecurrence(c.When)
+		if err != nil {
+			return nil, fmt.Errorf("room %s: %w", c.Room, err)
+		}
+		duration, err := time.ParseDuration(c.Duration)
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("room %s: invalid duration %q", c.Room, c.Duration)
+		}
+		// Templates are only looked up when the room opens
+		opts := c.options()
+		if _, err := parseRoomOptions(opts); err != nil {
+			return nil, fmt.Errorf("room %s: %w", c.Room, err)
+		}
+		schedules = append(schedules, Schedule{
+			Room:     c.Room,
+			When:     when,
+			Duration: duration,
+			Options:  opts,
+		})
+	}
+	return schedules, nil
+}
+
+// RunSchedules opens and closes each scheduled room on time until ctx is
+// done. A meeting already under way when it starts is opened straight away.
+func (s *Server) RunSchedules(ctx context.Context, schedules []Schedule) {
+	var wg sync.WaitGroup
+	for _, sched := range schedules {
+		wg.Go(func() {
+			s.runSchedule(ctx, sched)
+		})
+	}
+	wg.Wait()
+}
+
+func (s *Server) runSchedule(ctx context.Context, sched Schedule) {
+	for {
+		start := sched.When.Next(time.Now().Add(-sched.Duration))
+		if start.IsZero() {
+			return
+		}
+		end := start.Add(sched.Duration)
+		slog.Info("scheduled room", "room", sched.Room, "start", start, "end", end)
+		if !sleepUntil(ctx, start) {
+			return
+		}
+		s.openScheduled(sched, end)
+		if !sleepUntil(ctx, end) {
+			return
+		}
+	}
+}
+
+// sleepUntil waits for t, reporting false if ctx is done first.
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// openScheduled creates a scheduled room and keeps it open until end. A room
+// already open under its name is only taken over if it is this meeting,
+// restored after a restart: any other was made with its own options, which
+// can't be swapped for the schedule's while people are in it.
+func (s *Server) openScheduled(sched Schedule, end time.Time) {
+	room, err := s.NewRoom(sched.Room, sched.Options)
+	if errors.Is(err, ErrRoomExists) {
+		room, err = s.GetRoom(sched.Room)
+		if err == nil && !room.closesAtEqual(end) {
+			err = ErrRoomExists
+		}
+	}
+	if err != nil {
+		slog.Error("failed opening scheduled room", "room", sched.Room, "error", err)
+		return
+	}
+	room.mu.Lock()
+	room.closesAt = end
+	room.persist()
+	room.mu.Unlock()
+	slog.Info("opened scheduled room", "room", sched.Room, "until", end)
+
+	time.AfterFunc(time.Until(end), func() {
+		room.mu.Lock()
+		defer room.mu.Unlock()
+		if !room.closed {
+			room.close("the scheduled meeting is over")
+		}
+	})
+}
+
+// closesAtEqual reports whether the room is a scheduled one ending at end.
+func (r *Room) closesAtEqual(end time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.closesAt.Equal(end)
+}
@@ -2,9 +2,15 @@ package server
 
 import (
 	"errors"
+	"fmt"
 	"log/slog"
+	"maps"
+	"math/rand/v2"
 	"net/http"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/abennett/ttt/pkg"
 	"github.com/abennett/ttt/pkg/messages"
@@ -12,25 +18,96 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+// DefaultGracePeriod is how long a dropped participant's place is held.
+const DefaultGracePeriod = 30 * time.Second
+
+// defaultDice is what rooms roll unless they are created with other dice.
+var defaultDice = pkg.DiceRoll{
+	Count:     1,
+	DiceSides: 20,
+}
+
+const (
+	// maxDice and maxDiceSides keep rolls quick to make and to read.
+	maxDice      = 100
+	maxDiceSides = 1000
+)
+
+// parseDice reads the dice a room rolls, refusing any that can't be rolled
+// or would take too long to.
+func parseDice(s string) (pkg.DiceRoll, error) {
+	dice, err := pkg.ParseDiceRoll(s)
+	if err != nil {
+		return dice, fmt.Errorf("invalid dice %q: %w", s, err)
+	}
+	if dice.Count < 1 || dice.Count > maxDice || dice.DiceSides < 1 || dice.DiceSides > maxDiceSides {
+		return dice, fmt.Errorf("invalid dice %q: roll 1 to %d dice of 1 to %d sides", s, maxDice, maxDiceSides)
+	}
+	return dice, nil
+}
+
 var (
 	ErrRoomExists    = errors.New("room exists")
 	ErrRoomNotExists = errors.New("room does not exist")
+	ErrNotHost       = errors.New("only the host can do that")
+	ErrInvalidStatus = errors.New("status cannot be set")
+
+	ErrInvalidRoomOptions = errors.New("invalid room options")
 )
 
 type Server struct {
-	rw       *sync.RWMutex
-	upgrader websocket.Upgrader
+	rw         *sync.RWMutex
+	upgrader   websocket.Upgrader
+	duplicates DuplicatePolicy
+	grace      time.Duration
+	picks      *pickHistory
+	templates  *templates
+	adminToken string
+	store      RoomStore
+	stats      StatsStore
+	eventDir   string
+	// idleTimeout and roomTTL are enforced by RunJanitor.
+	idleTimeout time.Duration
+	roomTTL     time.Duration
 
 	rooms map[string]*Room
 }
 
-func NewServer() *Server {
-	return &Server{
-		rw:    &sync.RWMutex{},
-		rooms: map[string]*Room{},
+// Option configures a Server.
+type Option func(*Server)
+
+// WithDuplicatePolicy sets how rooms handle a username that is already
+// connected. Rooms reject duplicates by default.
+func WithDuplicatePolicy(p DuplicatePolicy) Option {
+	return func(s *Server) {
+		s.duplicates = p
+	}
+}
+
+// WithGracePeriod sets how long a participant whose connection drops keeps
+// their place in the room. Zero removes them straight away.
+func WithGracePeriod(d time.Duration) Option {
+	return func(s *Server) {
+		s.grace = d
 	}
 }
 
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		rw:         &sync.RWMutex{},
+		duplicates: DuplicateReject,
+		grace:      DefaultGracePeriod,
+		picks:      newPickHistory(),
+		templates:  newTemplates(),
+		stats:      newMemoryStats(),
+		rooms:      map[string]*Room{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	roomName := chi.URLParam(r, "roomName")
 	if roomName == "" {
@@ -38,15 +115,11 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	slog.Info("serving request", "roomName", roomName)
-	var err error
-	room, ok := s.rooms[roomName]
-	if !ok {
-		room, err = s.NewRoom(roomName)
-		if err != nil {
-			slog.Error("unable to create new room", "room_name", roomName, "error", err)
-			http.Error(w, "unable to create new room", http.StatusInternalServerError)
-			return
-		}
+	room, status, err := s.enterRoom(r, roomName)
+	if err != nil {
+		slog.Info("refused entry", "room", roomName, "error", err)
+		http.Error(w, err.Error(), status)
+		return
 	}
 	conn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -60,42 +133,220 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	room.RunSession(r.Context(), conn)
 
 	room.mu.Lock()
-	if len(room.userSessions) == 0 {
-		s.deleteRoom(roomName)
-		slog.Info("closed room", "room", roomName)
-	}
+	room.closeIfEmpty()
 	room.mu.Unlock()
 }
 
-func (s *Server) NewRoom(name string) (*Room, error) {
+// enterRoom finds the room a request is for, creating it from the request's
+// options if needed, and checks the request may join it. On failure it
+// returns the HTTP status to refuse the request with.
+func (s *Server) enterRoom(r *http.Request, roomName string) (*Room, int, error) {
+	password := r.Header.Get(messages.PasswordHeader)
+	room, err := s.GetRoom(roomName)
+	if err != nil {
+		opts, err := messages.ParseRoomOptions(r.URL.Query())
+		if err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+		opts.Password = password
+		room, err = s.NewRoom(roomName, opts)
+		switch {
+		case errors.Is(err, ErrRoomExists):
+			if room, err = s.GetRoom(roomName); err != nil {
+				return nil, http.StatusInternalServerError, err
+			}
+		case errors.Is(err, ErrInvalidRoomOptions):
+			return nil, http.StatusBadRequest, err
+		case err != nil:
+			slog.Error("unable to create new room", "room_name", roomName, "error", err)
+			return nil, http.StatusInternalServerError, errors.New("unable to create new room")
+		default:
+			// The creator set the password, or was given the join code
+			password = room.secret
+		}
+	}
+
+	if err = room.checkPassword(password); errors.Is(err, ErrPasswordRequired) {
+		return nil, http.StatusUnauthorized, err
+	} else if err != nil {
+		return nil, http.StatusForbidden, err
+	}
+	// Spectators take no place. Anyone who claims to be one but asks for a
+	// place is checked again once they do.
+	if r.Header.Get(messages.WatchHeader) != "" {
+		return room, http.StatusOK, nil
+	}
+	if err = room.checkEntry(r.Header.Get(messages.ResumeHeader)); err != nil {
+		return nil, http.StatusForbidden, err
+	}
+	return room, http.StatusOK, nil
+}
+
+// roomSettings are a room's options once checked.
+type roomSettings struct {
+	dice     pkg.DiceRoll
+	ordering Ordering
+	tieBreak TieBreak
+	lateJoin LateJoin
+	teams    TeamOrder
+	mode     Mode
+	deck     Deck
+	timebox  time.Duration
+	agenda   []messages.Phase
+	roster   []string
+}
+
+// parseRoomOptions checks every option, filling in defaults for those left
+// empty.
+func parseRoomOptions(opts messages.RoomOptions) (roomSettings, error) {
+	var (
+		settings roomSettings
+		err      error
+	)
+	settings.dice = defaultDice
+	if opts.Dice != "" {
+		if settings.dice, err = parseDice(opts.Dice); err != nil {
+			return settings, err
+		}
+	}
+	if settings.ordering, err = NewOrdering(opts.Ordering); err != nil {
+		return settings, err
+	}
+	if settings.tieBreak, err = ParseTieBreak(opts.TieBreak); err != nil {
+		return settings, err
+	}
+	if settings.lateJoin, err = ParseLateJoin(opts.LateJoin); err != nil {
+		return settings, err
+	}
+	if settings.teams, err = ParseTeamOrder(opts.Teams); err != nil {
+		return settings, err
+	}
+	if settings.mode, err = ParseMode(opts.Mode); err != nil {
+		return settings, err
+	}
+	if settings.deck, err = ParseDeck(opts.Deck); err != nil {
+		return settings, err
+	}
+	if opts.Timebox != "" {
+		if settings.timebox, err = time.ParseDuration(opts.Timebox); err != nil || settings.timebox < 0 {
+			return settings, fmt.Errorf("invalid timebox %q", opts.Timebox)
+		}
+	}
+	base := roomBase{mode: settings.mode, dice: settings.dice, timebox: settings.timebox}
+	for _, phase := range opts.Agenda {
+		if _, err := base.with(phase); err != nil {
+			return settings, err
+		}
+	}
+	settings.agenda = opts.Agenda
+	if opts.Capacity < 0 {
+		return settings, errors.New("capacity must not be negative")
+	}
+	for _, user := range opts.Roster {
+		user = strings.TrimSpace(user)
+		if user != "" && !slices.Contains(settings.roster, user) {
+			settings.roster = append(settings.roster, user)
+		}
+	}
+	return settings, nil
+}
+
+func (s *Server) NewRoom(name string, opts messages.RoomOptions) (*Room, error) {
+	opts, err := s.applyTemplate(opts)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidRoomOptions, err)
+	}
+	settings, err := parseRoomOptions(opts)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidRoomOptions, err)
+	}
+	secret := opts.Password
+	var joinCode string
+	if opts.JoinCode {
+		joinCode = newJoinCode()
+		secret = joinCode
+	}
+
 	s.rw.Lock()
 	defer s.rw.Unlock()
 	_, ok := s.rooms[name]
 	if ok {
 		return nil, ErrRoomExists
 	}
-	s.rooms[name] = &Room{
-		mu:           new(sync.Mutex),
-		logger:       slog.With("room", name),
-		userSessions: make(map[string]userSession),
-		Version:      0,
-		Dice: pkg.DiceRoll{
-			Count:     1,
-			DiceSides: 20,
-		},
-		Name:  name,
-		Rolls: map[string]*messages.RollResult{},
-	}
-	return s.rooms[name], nil
+	room := s.newRoom(name)
+	room.Dice = settings.dice
+	room.Ordering = settings.ordering
+	room.TieBreak = settings.tieBreak
+	room.LateJoin = settings.lateJoin
+	room.Teams = settings.teams
+	room.Mode = settings.mode
+	room.Deck = settings.deck
+	room.Timebox = settings.timebox
+	room.base = roomBase{mode: settings.mode, dice: settings.dice, timebox: settings.timebox}
+	room.Agenda = settings.agenda
+	if len(room.Agenda) > 0 {
+		// Checked while parsing, so this cannot fail
+		_ = room.enterPhase(0)
+	}
+	room.Roster = settings.roster
+	room.Capacity = opts.Capacity
+	room.secret = secret
+	room.joinCode = joinCode
+	s.startEventLog(room)
+	s.rooms[name] = room
+	return room, nil
+}
+
+// newRoom builds an empty room with the server's settings and defaults.
+func (s *Server) newRoom(name string) *Room {
+	seed := rand.Uint64()
+	room := &Room{
+		mu:            new(sync.Mutex),
+		logger:        slog.With("room", name),
+		userSessions:  make(map[uint64]userSession),
+		duplicates:    s.duplicates,
+		gracePeriod:   s.grace,
+		resumeTokens:  make(map[string]string),
+		graceTimers:   make(map[string]*time.Timer),
+		reactionTimes: make(map[string][]time.Time),
+		picks:         s.picks,
+		store:         s.store,
+		createdAt:     time.Now(),
+		lastActive:    time.Now(),
+		finishedAt:    make(map[string]time.Time),
+		absentees:     make(map[string]bool),
+		rng:           rand.New(rand.NewPCG(seed, seed)),
+		seed:          seed,
+		Version:       0,
+		Dice:          defaultDice,
+		Name:          name,
+		Rolls:         map[string]*messages.RollResult{},
+	}
+	room.onEmpty = func() {
+		s.deleteRoom(name, room)
+	}
+	return room
 }
 
+// GetRooms returns a copy of every room, each taken with the room locked so
+// it is safe to read while the room carries on.
 func (s *Server) GetRooms() map[string]Room {
+	// Rooms are locked before the server, so take the list first
 	s.rw.RLock()
-	defer s.rw.RUnlock()
+	live := maps.Clone(s.rooms)
+	s.rw.RUnlock()
 
-	rooms := make(map[string]Room, len(s.rooms))
-	for k, v := range s.rooms {
-		rooms[k] = *v
+	rooms := make(map[string]Room, len(live))
+	for k, v := range live {
+		v.mu.Lock()
+		room := *v
+		room.Rolls = make(map[string]*messages.RollResult, len(v.Rolls))
+		for user, roll := range v.Rolls {
+			rr := *roll
+			room.Rolls[user] = &rr
+		}
+		v.mu.Unlock()
+		rooms[k] = room
 	}
 	return rooms
 }
@@ -110,8 +361,24 @@ func (s *Server) GetRoom(roomName string) (*Room, error) {
 	return room, nil
 }
 
-func (s *Server) deleteRoom(roomName string) {
+// deleteRoom removes room, unless the name has since been taken by another.
+func (s *Server) deleteRoom(roomName string, room *Room) {
 	s.rw.Lock()
+	defer s.rw.Unlock()
+	if s.rooms[roomName] != room {
+		return
+	}
 	delete(s.rooms, roomName)
-	s.rw.Unlock()
+	s.recordSession(room)
+	if room.events != nil {
+		if err := room.events.Close(); err != nil {
+			slog.Error("failed closing event log", "room", roomName, "error", err)
+		}
+	}
+	if s.store != nil {
+		if err := s.store.Delete(roomName); err != nil {
+			slog.Error("failed deleting stored room", "room", roomName, "error", err)
+		}
+	}
+	slog.Info("closed room", "room", roomName)
 }
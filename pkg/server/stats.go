@@ -0,0 +1,251 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/abennett/ttt/pkg/messages"
+)
+
+// StatsStore keeps a record of every finished session, by room name.
+type StatsStore interface {
+	RecordSession(s SessionRecord) error
+	// Sessions returns a room's sessions, oldest first.
+	Sessions(room string) ([]SessionRecord, error)
+}
+
+// SessionRecord is how a room stood when its last participant left.
+type SessionRecord struct {
+	Room         string              `json:"room"`
+	Start        time.Time           `json:"start"`
+	End          time.Time           `json:"end"`
+	Participants []ParticipantRecord `json:"participants"`
+	// SecretHash is set if the room had a password or join code, which is
+	// then needed to see the session in the room's history.
+	SecretHash string `json:"secret_hash,omitempty"`
+}
+
+// hashSecret is what a session keeps of its room's secret.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// visibleWith reports whether the session can be seen with password.
+func (s SessionRecord) visibleWith(password string) bool {
+	if s.SecretHash == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(hashSecret(password)), []byte(s.SecretHash)) == 1
+}
+
+// ParticipantRecord is one participant's part in a session.
+type ParticipantRecord struct {
+	User   string `json:"user"`
+	Result int    `json:"result"`
+	// Position is their place in the final order, from 1.
+	Position   int             `json:"position"`
+	Status     messages.Status `json:"status"`
+	TimeToDone time.Duration   `json:"time_to_done,omitempty"`
+}
+
+// memoryStats keeps session records for as long as the server runs.
+type memoryStats struct {
+	mu       sync.Mutex
+	sessions map[string][]SessionRecord
+}
+
+func newMemoryStats() *memoryStats {
+	return &memoryStats{sessions: make(map[string][]SessionRecord)}
+}
+
+func (m *memoryStats) RecordSession(s SessionRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[s.Room] = append(m.sessions[s.Room], s)
+	return nil
+}
+
+func (m *memoryStats) Sessions(room string) ([]SessionRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.sessions[room]), nil
+}
+
+func (fs *FileStore) statsPath(room string) string {
+	return filepath.Join(fs.dir, "stats", url.PathEscape(room)+".jsonl")
+}
+
+// RecordSession appends a session to the room's stats file.
+func (fs *FileStore) RecordSession(s SessionRecord) error {
+	path := fs.statsPath(s.Room)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(b, '\n')); err != nil {
+		f.Close() //nolint: errcheck
+		return err
+	}
+	return f.Close()
+}
+
+func (fs *FileStore) Sessions(room string) ([]SessionRecord, error) {
+	f, err := os.Open(fs.statsPath(room))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() //nolint: errcheck
+	var sessions []SessionRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var s SessionRecord
+		if err := json.Unmarshal(scanner.Bytes(), &s); err != nil {
+			return nil, fmt.Errorf("bad session record: %w", err)
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, scanner.Err()
+}
+
+// trackFinished notes when participants finish their turn, and forgets it
+// if they go back to waiting. The caller must hold r.mu.
+func (r *Room) trackFinished() {
+	for user, roll := range r.Rolls {
+		finished := roll.Status == messages.StatusDone || roll.Status == messages.StatusPassed
+		_, noted := r.finishedAt[user]
+		switch {
+		case finished && !noted:
+			r.finishedAt[user] = time.Now()
+		case !finished && noted:
+			delete(r.finishedAt, user)
+		}
+	}
+}
+
+// sessionRecord sums up the room as it closes. The caller must hold r.mu.
+func (r *Room) sessionRecord() SessionRecord {
+	record := SessionRecord{
+		Room:  r.Name,
+		Start: r.createdAt,
+		End:   time.Now(),
+	}
+	if r.secret != "" {
+		record.SecretHash = hashSecret(r.secret)
+	}
+	for idx, roll := range r.sortedRolls() {
+		p := ParticipantRecord{
+			User:     roll.User,
+			Result:   roll.Result,
+			Position: idx + 1,
+			Status:   roll.Status,
+		}
+		if at, ok := r.finishedAt[roll.User]; ok {
+			p.TimeToDone = at.Sub(r.createdAt)
+		}
+		record.Participants = append(record.Participants, p)
+	}
+	return record
+}
+
+// recordSession saves the stats of a room that is closing, unless no one
+// ever took part. The caller must hold r.mu.
+func (s *Server) recordSession(r *Room) {
+	if len(r.Rolls) == 0 {
+		return
+	}
+	if err := s.stats.RecordSession(r.sessionRecord()); err != nil {
+		slog.Error("failed recording session", "room", r.Name, "error", err)
+	}
+}
+
+// History gathers each user's record across the sessions of rooms called
+// room. Sessions of protected rooms are left out unless password is the one
+// the room had.
+func (s *Server) History(room, password string) (messages.RoomHistory, error) {
+	all, err := s.stats.Sessions(room)
+	if err != nil {
+		return messages.RoomHistory{}, err
+	}
+	sessions := slices.DeleteFunc(all, func(s SessionRecord) bool {
+		return !s.visibleWith(password)
+	})
+	history := messages.RoomHistory{
+		Room:     room,
+		Sessions: len(sessions),
+	}
+	users := make(map[string]int)
+	for _, session := range sessions {
+		for _, p := range session.Participants {
+			idx, ok := users[p.User]
+			if !ok {
+				idx = len(history.Users)
+				users[p.User] = idx
+				history.Users = append(history.Users, messages.UserHistory{User: p.User})
+			}
+			history.Users[idx].Entries = append(history.Users[idx].Entries, messages.HistoryEntry{
+				At:         session.Start,
+				Result:     p.Result,
+				Position:   p.Position,
+				Of:         len(session.Participants),
+				TimeToDone: p.TimeToDone,
+				Status:     p.Status,
+			})
+		}
+	}
+	slices.SortFunc(history.Users, func(a, b messages.UserHistory) int {
+		return strings.Compare(a.User, b.User)
+	})
+	return history, nil
+}
+
+// ServeHistory sends a room's history as a msgpack message, including the
+// sessions protected by the password or join code in PasswordHeader.
+func (s *Server) ServeHistory(w http.ResponseWriter, r *http.Request) {
+	roomName := chi.URLParam(r, "roomName")
+	history, err := s.History(roomName, r.Header.Get(messages.PasswordHeader))
+	if err != nil {
+		slog.Error("failed loading history", "room", roomName, "error", err)
+		http.Error(w, "unable to load history", http.StatusInternalServerError)
+		return
+	}
+	b, err := msgpack.Marshal(messages.Message{
+		Type:    messages.HistoryMsgType,
+		Version: "1",
+		Payload: history,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/msgpack")
+	_, _ = w.Write(b)
+}
@@ -0,0 +1,360 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/abennett/ttt/pkg"
+	"github.com/abennett/ttt/pkg/messages"
+)
+
+// RoomStore keeps rooms somewhere that outlives the server process.
+type RoomStore interface {
+	// Save replaces the stored copy of a room.
+	Save(snap RoomSnapshot) error
+	// Delete forgets a room. Deleting a room that isn't stored is not an
+	// error.
+	Delete(name string) error
+	// Load returns every stored room.
+	Load() ([]RoomSnapshot, error)
+}
+
+// RoomSnapshot is everything needed to bring a room back after a restart.
+// Sessions aren't included: everyone comes back disconnected, with their
+// place held for the grace period.
+type RoomSnapshot struct {
+	Name        string           `json:"name"`
+	Version     int              `json:"version"`
+	Host        string           `json:"host"`
+	Dice        string           `json:"dice"`
+	Ordering    string           `json:"ordering"`
+	ShuffleSeed uint64           `json:"shuffle_seed,omitempty"`
+	TieBreak    string           `json:"tie_break"`
+	LateJoin    string           `json:"late_join"`
+	Teams       string           `json:"teams,omitempty"`
+	Mode        string           `json:"mode"`
+	Deck        string           `json:"deck"`
+	Timebox     time.Duration    `json:"timebox,omitempty"`
+	Agenda      []messages.Phase `json:"agenda,omitempty"`
+	Phase       int              `json:"phase,omitempty"`
+	// Base is what the room was created with, which phases start from.
+	Base        *messages.Phase        `json:"base,omitempty"`
+	Revealed    bool                   `json:"revealed"`
+	Capacity    int                    `json:"capacity"`
+	Locked      bool                   `json:"locked"`
+	Rolls       []messages.RollResult  `json:"rolls"`
+	Roster      []string               `json:"roster,omitempty"`
+	Absentees   []string               `json:"absentees,omitempty"`
+	UserCounter uint32                 `json:"user_counter"`
+	ManualOrder []string               `json:"manual_order,omitempty"`
+	Chat        []messages.ChatMessage `json:"chat,omitempty"`
+	Poll        *PollSnapshot          `json:"poll,omitempty"`
+	PollCounter int                    `json:"poll_counter"`
+	LastPick    *messages.Pick         `json:"last_pick,omitempty"`
+	// Secret is the room's password or join code, kept so protected rooms
+	// stay protected.
+	Secret       string            `json:"secret,omitempty"`
+	JoinCode     string            `json:"join_code,omitempty"`
+	ResumeTokens map[string]string `json:"resume_tokens"`
+	// CreatedAt and FinishedAt are kept for the session's stats.
+	CreatedAt  time.Time            `json:"created_at"`
+	FinishedAt map[string]time.Time `json:"finished_at,omitempty"`
+	// ClosesAt is when a scheduled room's slot ends.
+	ClosesAt time.Time `json:"closes_at"`
+}
+
+// PollSnapshot is an open poll, including who voted for what.
+type PollSnapshot struct {
+	ID         int            `json:"id"`
+	Question   string         `json:"question"`
+	Options    []string       `json:"options"`
+	FistOfFive bool           `json:"fist_of_five"`
+	Anonymous  bool           `json:"anonymous"`
+	Votes      map[string]int `json:"votes"`
+}
+
+// WithStore saves every room to store as it changes, so Restore can bring
+// them back after a restart. If store is also a StatsStore, session history
+// is kept there too rather than in memory.
+func WithStore(store RoomStore) Option {
+	return func(s *Server) {
+		s.store = store
+		if stats, ok := store.(StatsStore); ok {
+			s.stats = stats
+		}
+	}
+}
+
+// minRestoreGrace is the least time places are held after a restart, so a
+// server with no grace period doesn't drop every restored room on start.
+const minRestoreGrace = DefaultGracePeriod
+
+// Restore brings back the rooms in the server's store. Everyone in them is
+// held as disconnected until they reconnect or the grace period runs out,
+// which is never shorter than minRestoreGrace.
+func (s *Server) Restore() error {
+	if s.store == nil {
+		return nil
+	}
+	if err := s.restoreTemplates(); err != nil {
+		return err
+	}
+	snaps, err := s.store.Load()
+	if err != nil {
+		return fmt.Errorf("unable to load rooms: %w", err)
+	}
+	for _, snap := range snaps {
+		room, err := s.restoreRoom(snap)
+		if err != nil {
+			slog.Error("failed restoring room", "room", snap.Name, "error", err)
+			continue
+		}
+		room.mu.Lock()
+		for user := range room.Rolls {
+			room.holdPlaceFor(user, max(room.gracePeriod, minRestoreGrace))
+		}
+		s.startEventLog(room)
+		room.closeIfEmpty()
+		room.mu.Unlock()
+		slog.Info("restored room", "room", snap.Name, "participants", len(snap.Rolls))
+	}
+	return nil
+}
+
+func (s *Server) restoreRoom(snap RoomSnapshot) (*Room, error) {
+	dice, err := pkg.ParseDiceRoll(snap.Dice)
+	if err != nil {
+		return nil, err
+	}
+	ordering, err := NewOrdering(snap.Ordering)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := ordering.(shuffled); ok {
+		ordering = shuffled{seed: snap.ShuffleSeed}
+	}
+
+	s.rw.Lock()
+	defer s.rw.Unlock()
+	if _, ok := s.rooms[snap.Name]; ok {
+		return nil, ErrRoomExists
+	}
+	room := s.newRoom(snap.Name)
+	room.Version = snap.Version
+	room.Host = snap.Host
+	room.Dice = dice
+	room.Ordering = ordering
+	room.TieBreak = TieBreak(snap.TieBreak)
+	room.LateJoin = LateJoin(snap.LateJoin)
+	room.Teams = TeamOrder(snap.Teams)
+	room.Mode = Mode(snap.Mode)
+	room.Deck = Deck(snap.Deck)
+	room.Timebox = snap.Timebox
+	room.base = roomBase{mode: room.Mode, dice: dice, timebox: room.Timebox}
+	if snap.Base != nil {
+		if room.base, err = (roomBase{}).with(*snap.Base); err != nil {
+			return nil, err
+		}
+	}
+	room.Agenda = snap.Agenda
+	room.Phase = snap.Phase
+	room.Revealed = snap.Revealed
+	room.Capacity = snap.Capacity
+	room.Locked = snap.Locked
+	for _, roll := range snap.Rolls {
+		room.Rolls[roll.User] = &roll
+	}
+	room.Roster = snap.Roster
+	for _, user := range snap.Absentees {
+		room.absentees[user] = true
+	}
+	room.userCounter = snap.UserCounter
+	room.manualOrder = snap.ManualOrder
+	room.chat = snap.Chat
+	if p := snap.Poll; p != nil {
+		room.poll = &poll{
+			id:         p.ID,
+			question:   p.Question,
+			options:    p.Options,
+			fistOfFive: p.FistOfFive,
+			anonymous:  p.Anonymous,
+			votes:      p.Votes,
+		}
+	}
+	room.pollCounter = snap.PollCounter
+	room.lastPick = snap.LastPick
+	room.secret = snap.Secret
+	room.joinCode = snap.JoinCode
+	if snap.ResumeTokens != nil {
+		room.resumeTokens = snap.ResumeTokens
+	}
+	if !snap.CreatedAt.IsZero() {
+		room.createdAt = snap.CreatedAt
+	}
+	if snap.FinishedAt != nil {
+		room.finishedAt = snap.FinishedAt
+	}
+	room.closesAt = snap.ClosesAt
+	s.rooms[snap.Name] = room
+	return room, nil
+}
+
+// snapshot captures the room for its store. The caller must hold r.mu.
+func (r *Room) snapshot() RoomSnapshot {
+	snap := RoomSnapshot{
+		Name:         r.Name,
+		Version:      r.Version,
+		Host:         r.Host,
+		Dice:         r.Dice.String(),
+		Ordering:     r.Ordering.Name(),
+		TieBreak:     string(r.TieBreak),
+		LateJoin:     string(r.LateJoin),
+		Teams:        string(r.Teams),
+		Mode:         string(r.Mode),
+		Deck:         string(r.Deck),
+		Timebox:      r.Timebox,
+		Agenda:       slices.Clone(r.Agenda),
+		Phase:        r.Phase,
+		Revealed:     r.Revealed,
+		Capacity:     r.Capacity,
+		Locked:       r.Locked,
+		Roster:       slices.Clone(r.Roster),
+		Absentees:    slices.Sorted(maps.Keys(r.absentees)),
+		UserCounter:  r.userCounter,
+		ManualOrder:  slices.Clone(r.manualOrder),
+		Chat:         slices.Clone(r.chat),
+		PollCounter:  r.pollCounter,
+		LastPick:     r.lastPick,
+		Secret:       r.secret,
+		JoinCode:     r.joinCode,
+		ResumeTokens: maps.Clone(r.resumeTokens),
+		CreatedAt:    r.createdAt,
+		FinishedAt:   maps.Clone(r.finishedAt),
+		ClosesAt:     r.closesAt,
+	}
+	if s, ok := r.Ordering.(shuffled); ok {
+		snap.ShuffleSeed = s.seed
+	}
+	for _, roll := range r.Rolls {
+		snap.Rolls = append(snap.Rolls, *roll)
+	}
+	slices.SortFunc(snap.Rolls, func(a, b messages.RollResult) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	if p := r.poll; p != nil {
+		snap.Poll = &PollSnapshot{
+			ID:         p.id,
+			Question:   p.question,
+			Options:    p.options,
+			FistOfFive: p.fistOfFive,
+			Anonymous:  p.anonymous,
+			Votes:      maps.Clone(p.votes),
+		}
+	}
+	if len(r.Agenda) > 0 {
+		base := r.base.phase()
+		snap.Base = &base
+	}
+	return snap
+}
+
+// persist saves the room to its store, if it has one. A failed save is
+// logged rather than failing the update that caused it.
+// The caller must hold r.mu.
+func (r *Room) persist() {
+	if r.store == nil {
+		return
+	}
+	if err := r.store.Save(r.snapshot()); err != nil {
+		r.logger.Error("failed saving room", "error", err)
+	}
+}
+
+// FileStore keeps each room as a JSON file in a directory.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore stores rooms in dir, creating it if needed.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (fs *FileStore) path(name string) string {
+	return filepath.Join(fs.dir, url.PathEscape(name)+".json")
+}
+
+func (fs *FileStore) Save(snap RoomSnapshot) error {
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(fs.path(snap.Name), b)
+}
+
+// writeFileAtomic writes then renames, so a crash never leaves half a file
+// behind.
+func writeFileAtomic(path string, b []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) //nolint: errcheck
+	if _, err = f.Write(b); err != nil {
+		f.Close() //nolint: errcheck
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (fs *FileStore) Delete(name string) error {
+	err := os.Remove(fs.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Load reads every room in the directory. Files that can't be read are
+// logged and skipped so one bad room doesn't keep the rest down.
+func (fs *FileStore) Load() ([]RoomSnapshot, error) {
+	entries, err := os.ReadDir(fs.dir)
+	if err != nil {
+		return nil, err
+	}
+	var snaps []RoomSnapshot
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(fs.dir, entry.Name())
+		b, err := os.ReadFile(path)
+		if err != nil {
+			slog.Error("failed reading room", "path", path, "error", err)
+			continue
+		}
+		var snap RoomSnapshot
+		if err := json.Unmarshal(b, &snap); err != nil {
+			slog.Error("failed parsing room", "path", path, "error", err)
+			continue
+		}
+		snaps = append(snaps, snap)
+	}
+	return snaps, nil
+}
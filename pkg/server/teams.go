@@ -0,0 +1,90 @@
+package server
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/abennett/ttt/pkg/messages"
+)
+
+var ErrUnknownTeamOrder = errors.New("unknown team ordering")
+
+// TeamOrder decides how teams are ordered when a room goes team by team.
+type TeamOrder string
+
+const (
+	// TeamsOff orders everyone together, ignoring teams.
+	TeamsOff TeamOrder = ""
+	// TeamsCaptain orders teams by the roll of their captain, the first of
+	// them to join.
+	TeamsCaptain TeamOrder = "captain"
+	// TeamsAverage orders teams by the average roll of their members.
+	TeamsAverage TeamOrder = "average"
+)
+
+func ParseTeamOrder(s string) (TeamOrder, error) {
+	switch to := TeamOrder(s); to {
+	case TeamsOff, TeamsCaptain, TeamsAverage:
+		return to, nil
+	default:
+		return "", fmt.Errorf("%w %q", ErrUnknownTeamOrder, s)
+	}
+}
+
+// team stands in for a whole team when ordering teams against each other,
+// so the room's Ordering can compare them like participants.
+func (to TeamOrder) team(name string, members []messages.RollResult) messages.RollResult {
+	captain := members[0]
+	var sum int
+	for _, m := range members {
+		sum += m.Result
+		if m.ID < captain.ID {
+			captain = m
+		}
+	}
+	result := captain.Result
+	if to == TeamsAverage {
+		result = sum / len(members)
+	}
+	return messages.RollResult{
+		User:     name,
+		ID:       captain.ID,
+		Result:   result,
+		JoinedAt: captain.JoinedAt,
+	}
+}
+
+// groupTeams stably rearranges sorted rolls so each team's members are
+// together, teams in the room's order and anyone without a team last.
+func (r *Room) groupTeams(rolls []messages.RollResult) {
+	if r.Teams == TeamsOff {
+		return
+	}
+	members := make(map[string][]messages.RollResult)
+	for _, roll := range rolls {
+		members[roll.Team] = append(members[roll.Team], roll)
+	}
+	teams := make(map[string]messages.RollResult, len(members))
+	for name, m := range members {
+		teams[name] = r.Teams.team(name, m)
+	}
+	rank := func(rr messages.RollResult) messages.RollResult {
+		return teams[rr.Team]
+	}
+	slices.SortStableFunc(rolls, func(a, b messages.RollResult) int {
+		if a.Team == b.Team {
+			return 0
+		}
+		// No team goes last
+		if a.Team == "" || b.Team == "" {
+			return cmp.Compare(b.Team, a.Team)
+		}
+		ta, tb := rank(a), rank(b)
+		return cmp.Or(
+			r.Ordering.Compare(ta, tb),
+			cmp.Compare(ta.User, tb.User),
+		)
+	})
+}
@@ -0,0 +1,366 @@
+package server
+
+import (
+	"cmp"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/abennett/ttt/pkg/messages"
+)
+
+var (
+	ErrUnknownTemplate  = errors.New("unknown template")
+	ErrInvalidTemplate  = errors.New("invalid template")
+	ErrTooManyTemplates = errors.New("too many templates")
+)
+
+const (
+	// MaxTemplates is how many templates the server holds before new ones
+	// are refused. Templates loaded from a file don't count against it.
+	MaxTemplates = 100
+	// MaxTemplateSize is the largest template accepted over HTTP, in bytes.
+	MaxTemplateSize = 16 << 10
+	// maxTemplateName is the longest a template's name can be, in characters.
+	maxTemplateName = 64
+)
+
+// Template is a named set of room options. Rooms created from it take its
+// options wherever the creator left their own empty.
+type Template struct {
+	Name    string
+	Options messages.RoomOptions
+}
+
+// TemplateStore keeps templates created through the API across restarts.
+type TemplateStore interface {
+	SaveTemplate(t Template) error
+	Templates() ([]Template, error)
+}
+
+// roomConfig is how room options are written in config files and the
+// template API.
+type roomConfig struct {
+	Template string           `json:"template,omitempty"`
+	Dice     string           `json:"dice,omitempty"`
+	Order    string           `json:"order,omitempty"`
+	TieBreak string           `json:"tiebreak,omitempty"`
+	LateJoin string           `json:"late,omitempty"`
+	Teams    string           `json:"teams,omitempty"`
+	Mode     string           `json:"mode,omitempty"`
+	Deck     string           `json:"deck,omitempty"`
+	Timebox  string           `json:"timebox,omitempty"`
+	Capacity int              `json:"max,omitempty"`
+	Agenda   []messages.Phase `json:"agenda,omitempty"`
+	Roster   []string         `json:"roster,omitempty"`
+}
+
+func (c roomConfig) options() messages.RoomOptions {
+	return messages.RoomOptions{
+		Template: c.Template,
+		Dice:     c.Dice,
+		Ordering: c.Order,
+		TieBreak: c.TieBreak,
+		LateJoin: c.LateJoin,
+		Teams:    c.Teams,
+		Mode:     c.Mode,
+		Deck:     c.Deck,
+		Timebox:  c.Timebox,
+		Capacity: c.Capacity,
+		Agenda:   c.Agenda,
+		Roster:   c.Roster,
+	}
+}
+
+func configFor(opts messages.RoomOptions) roomConfig {
+	return roomConfig{
+		Template: opts.Template,
+		Dice:     opts.Dice,
+		Order:    opts.Ordering,
+		TieBreak: opts.TieBreak,
+		LateJoin: opts.LateJoin,
+		Teams:    opts.Teams,
+		Mode:     opts.Mode,
+		Deck:     opts.Deck,
+		Timebox:  opts.Timebox,
+		Capacity: opts.Capacity,
+		Agenda:   opts.Agenda,
+		Roster:   opts.Roster,
+	}
+}
+
+// templateConfig is how a Template is written.
+type templateConfig struct {
+	Name string `json:"name"`
+	roomConfig
+}
+
+func (t Template) MarshalJSON() ([]byte, error) {
+	return json.Marshal(templateConfig{Name: t.Name, roomConfig: configFor(t.Options)})
+}
+
+func (t *Template) UnmarshalJSON(b []byte) error {
+	var c templateConfig
+	if err := json.Unmarshal(b, &c); err != nil {
+		return err
+	}
+	*t = Template{Name: c.Name, Options: c.options()}
+	return nil
+}
+
+// check makes sure the template could create a room.
+func (t Template) check() error {
+	if strings.TrimSpace(t.Name) == "" {
+		return fmt.Errorf("%w: no name", ErrInvalidTemplate)
+	}
+	if utf8.RuneCountInString(t.Name) > maxTemplateName {
+		return fmt.Errorf("%w: name is longer than %d characters", ErrInvalidTemplate, maxTemplateName)
+	}
+	if t.Options.Template != "" {
+		return fmt.Errorf("%w %s: templates cannot use other templates", ErrInvalidTemplate, t.Name)
+	}
+	if _, err := parseRoomOptions(t.Options); err != nil {
+		return fmt.Errorf("%w %s: %w", ErrInvalidTemplate, t.Name, err)
+	}
+	return nil
+}
+
+// LoadTemplates reads a JSON list of templates from path.
+func LoadTemplates(path string) ([]Template, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var templates []Template
+	if err := json.Unmarshal(b, &templates); err != nil {
+		return nil, fmt.Errorf("bad template file %s: %w", path, err)
+	}
+	for _, t := range templates {
+		if err := t.check(); err != nil {
+			return nil, err
+		}
+	}
+	return templates, nil
+}
+
+// templates holds a server's templates by name.
+type templates struct {
+	mu     sync.RWMutex
+	byName map[string]Template
+}
+
+func newTemplates() *templates {
+	return &templates{byName: make(map[string]Template)}
+}
+
+func (ts *templates) put(t Template) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.byName[t.Name] = t
+}
+
+func (ts *templates) get(name string) (Template, bool) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	t, ok := ts.byName[name]
+	return t, ok
+}
+
+// WithTemplates makes templates available to rooms created on the server.
+func WithTemplates(templates ...Template) Option {
+	return func(s *Server) {
+		for _, t := range templates {
+			s.templates.put(t)
+		}
+	}
+}
+
+// SaveTemplate adds or replaces a template, keeping it in the server's
+// store if that can hold templates. A new template is refused once the
+// server holds MaxTemplates.
+func (s *Server) SaveTemplate(t Template) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+	ts := s.templates
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if _, ok := ts.byName[t.Name]; !ok && len(ts.byName) >= MaxTemplates {
+		return fmt.Errorf("%w: the server holds %d", ErrTooManyTemplates, MaxTemplates)
+	}
+	if store, ok := s.store.(TemplateStore); ok {
+		if err := store.SaveTemplate(t); err != nil {
+			return err
+		}
+	}
+	ts.byName[t.Name] = t
+	return nil
+}
+
+// Templates lists the server's templates by name.
+func (s *Server) Templates() []Template {
+	s.templates.mu.RLock()
+	defer s.templates.mu.RUnlock()
+	list := make([]Template, 0, len(s.templates.byName))
+	for _, t := range s.templates.byName {
+		list = append(list, t)
+	}
+	slices.SortFunc(list, func(a, b Template) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return list
+}
+
+// restoreTemplates brings back templates saved through the API.
+func (s *Server) restoreTemplates() error {
+	store, ok := s.store.(TemplateStore)
+	if !ok {
+		return nil
+	}
+	saved, err := store.Templates()
+	if err != nil {
+		return fmt.Errorf("unable to load templates: %w", err)
+	}
+	for _, t := range saved {
+		s.templates.put(t)
+	}
+	return nil
+}
+
+// applyTemplate fills in the options left empty from the named template.
+func (s *Server) applyTemplate(opts messages.RoomOptions) (messages.RoomOptions, error) {
+	if opts.Template == "" {
+		return opts, nil
+	}
+	t, ok := s.templates.get(opts.Template)
+	if !ok {
+		return opts, fmt.Errorf("%w %q", ErrUnknownTemplate, opts.Template)
+	}
+	d := t.Options
+	opts.Dice = cmp.Or(opts.Dice, d.Dice)
+	opts.Ordering = cmp.Or(opts.Ordering, d.Ordering)
+	opts.TieBreak = cmp.Or(opts.TieBreak, d.TieBreak)
+	opts.LateJoin = cmp.Or(opts.LateJoin, d.LateJoin)
+	opts.Teams = cmp.Or(opts.Teams, d.Teams)
+	opts.Mode = cmp.Or(opts.Mode, d.Mode)
+	opts.Deck = cmp.Or(opts.Deck, d.Deck)
+	opts.Timebox = cmp.Or(opts.Timebox, d.Timebox)
+	opts.Capacity = cmp.Or(opts.Capacity, d.Capacity)
+	if len(opts.Agenda) == 0 {
+		opts.Agenda = d.Agenda
+	}
+	if len(opts.Roster) == 0 {
+		opts.Roster = d.Roster
+	}
+	return opts, nil
+}
+
+// ServeTemplates lists the server's templates as JSON.
+func (s *Server) ServeTemplates(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(s.Templates())
+}
+
+// WithAdminToken lets templates be created and replaced over HTTP by anyone
+// sending token as a bearer token. Without one, that is turned off.
+func WithAdminToken(token string) Option {
+	return func(s *Server) {
+		s.adminToken = token
+	}
+}
+
+// ServeSaveTemplate creates or replaces the template named in the URL from
+// the JSON body, for requests carrying the server's admin token.
+func (s *Server) ServeSaveTemplate(w http.ResponseWriter, r *http.Request) {
+	if s.adminToken == "" {
+		http.Error(w, "template changes are turned off", http.StatusForbidden)
+		return
+	}
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+		http.Error(w, "admin token required", http.StatusUnauthorized)
+		return
+	}
+	var t Template
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxTemplateSize)).Decode(&t); err != nil {
+		http.Error(w, "bad template: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	t.Name = chi.URLParam(r, "templateName")
+	err := s.SaveTemplate(t)
+	if errors.Is(err, ErrInvalidTemplate) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, ErrTooManyTemplates) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		slog.Error("failed saving template", "template", t.Name, "error", err)
+		http.Error(w, "unable to save template", http.StatusInternalServerError)
+		return
+	}
+	slog.Info("saved template", "template", t.Name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (fs *FileStore) templatePath(name string) string {
+	return filepath.Join(fs.dir, "templates", url.PathEscape(name)+".json")
+}
+
+// SaveTemplate writes a template to its own file.
+func (fs *FileStore) SaveTemplate(t Template) error {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	path := fs.templatePath(t.Name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b)
+}
+
+// Templates loads every saved template, skipping any it cannot read.
+func (fs *FileStore) Templates() ([]Template, error) {
+	dir := filepath.Join(fs.dir, "templates")
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var templates []Template
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		b, err := os.ReadFile(path)
+		if err != nil {
+			slog.Error("failed reading template", "path", path, "error", err)
+			continue
+		}
+		var t Template
+		if err := json.Unmarshal(b, &t); err != nil {
+			slog.Error("failed parsing template", "path", path, "error", err)
+			continue
+		}
+		templates = append(templates, t)
+	}
+	return templates, nil
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/abennett/ttt/pkg"
+	"github.com/abennett/ttt/pkg/messages"
+)
+
+var ErrUnknownTieBreak = errors.New("unknown tie-break")
+
+// TieBreak decides the order of participants whose results are equal.
+type TieBreak string
+
+const (
+	TieBreakJoinOrder    TieBreak = "join"
+	TieBreakRollOff      TieBreak = "rolloff"
+	TieBreakAlphabetical TieBreak = "alpha"
+	TieBreakModifier     TieBreak = "modifier"
+)
+
+var rollOffDice = pkg.DiceRoll{
+	Count:     1,
+	DiceSides: 20,
+}
+
+func ParseTieBreak(s string) (TieBreak, error) {
+	switch tb := TieBreak(s); tb {
+	case "":
+		return TieBreakJoinOrder, nil
+	case TieBreakJoinOrder, TieBreakRollOff, TieBreakAlphabetical, TieBreakModifier:
+		return tb, nil
+	default:
+		return "", fmt.Errorf("%w %q", ErrUnknownTieBreak, s)
+	}
+}
+
+// Compare orders two participants with the same result. Anything it leaves
+// equal falls back to join order.
+func (tb TieBreak) Compare(a, b messages.RollResult) int {
+	switch tb {
+	case TieBreakRollOff:
+		return cmp.Compare(b.RollOff, a.RollOff)
+	case TieBreakAlphabetical:
+		return cmp.Compare(a.User, b.User)
+	case TieBreakModifier:
+		return cmp.Compare(b.Modifier, a.Modifier)
+	default:
+		return 0
+	}
+}
+
+// rollOff gives everyone sharing a result an extra d20 to settle the tie.
+// Rolls that were already made are kept so the order stays stable.
+// The caller must hold r.mu.
+func (r *Room) rollOff() {
+	tied := make(map[int][]*messages.RollResult)
+	for _, roll := range r.Rolls {
+		tied[roll.Result] = append(tied[roll.Result], roll)
+	}
+	// Visit ties in join order so the seeded dice land the same way each time
+	results := slices.Sorted(maps.Keys(tied))
+	for _, result := range results {
+		group := tied[result]
+		if len(group) < 2 {
+			continue
+		}
+		slices.SortFunc(group, func(a, b *messages.RollResult) int {
+			return cmp.Compare(a.ID, b.ID)
+		})
+		for _, roll := range group {
+			if roll.RollOff == 0 {
+				roll.RollOff = rollOffDice.RollWith(r.rng)
+				r.logger.Debug("rolled off a tie", "user", roll.User, "roll_off", roll.RollOff)
+			}
+		}
+	}
+}
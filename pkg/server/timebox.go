@@ -0,0 +1,25 @@
+package server
+
+import (
+	"time"
+)
+
+// trackTurn notes when the turn passes to someone new, at now, so their
+// timebox can be shown. The caller must hold r.mu.
+func (r *Room) trackTurn(now time.Time) {
+	var speaker string
+	for _, roll := range r.sortedRolls() {
+		if !roll.Status.IsFinished() {
+			speaker = roll.User
+			break
+		}
+	}
+	if speaker == r.speaker {
+		return
+	}
+	r.speaker = speaker
+	r.turnStarted = time.Time{}
+	if speaker != "" {
+		r.turnStarted = now
+	}
+}
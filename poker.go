@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/abennett/ttt/pkg/messages"
+)
+
+var estimateStyle = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("#01c5d1")).
+	PaddingLeft(1)
+
+func (t *ttt) poker() bool {
+	return t.room.Mode == "poker"
+}
+
+// voteCell shows a participant's estimate once revealed, a face-down card
+// while hidden, and the receiving client's own pick either way.
+func voteCell(room messages.RoomState, rr messages.RollResult) string {
+	switch {
+	case rr.Vote != "":
+		return rr.Vote
+	case rr.User == room.You && room.YourVote != "":
+		return "🂠 " + room.YourVote
+	case rr.Voted:
+		return "🂠"
+	default:
+		return ""
+	}
+}
+
+// pickCard handles keys while choosing an estimate from the deck.
+func (t *ttt) pickCard(k string) (bool, error) {
+	t.voting = false
+	idx, err := strconv.Atoi(k)
+	if err != nil || idx < 1 || idx > len(t.room.Deck) {
+		return true, nil
+	}
+	card := t.room.Deck[idx-1]
+	if card == t.room.YourVote {
+		card = ""
+	}
+	return true, t.client.Vote(card)
+}
+
+func cardPrompt(deck []string) string {
+	parts := make([]string, len(deck))
+	for idx, card := range deck {
+		parts[idx] = strconv.Itoa(idx+1) + " " + card
+	}
+	return "vote: " + strings.Join(parts, "  ") + "  (any other key cancels)"
+}
+
+func renderEstimate(est messages.Estimate) string {
+	if est.Votes == 0 {
+		return estimateStyle.Render("no estimates")
+	}
+	parts := []string{fmt.Sprintf("%d votes", est.Votes)}
+	if est.Average != nil {
+		parts = append(parts, fmt.Sprintf("average %.1f", *est.Average))
+	}
+	parts = append(parts, "median "+est.Median)
+	if est.Consensus {
+		parts = append(parts, "🎯 consensus")
+	}
+	return estimateStyle.Render(strings.Join(parts, " • "))
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/abennett/ttt/pkg/messages"
+)
+
+// pollBarWidth is the length of the bar for an option everyone chose.
+const pollBarWidth = 20
+
+var (
+	pollStyle = lipgloss.NewStyle().
+			BorderStyle(lipgloss.RoundedBorder()).
+			BorderForeground(lipgloss.Color("#01c5d1")).
+			Padding(0, 1)
+	pollCursorStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("#01c5d1")).
+			Bold(true)
+	pollBarStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("#01c5d1"))
+	pollVotersStyle = lipgloss.NewStyle().
+			Faint(true)
+)
+
+// notePoll opens the poll overlay when the host asks something new.
+func (t *ttt) notePoll(next messages.RoomState) {
+	p := next.Poll
+	switch {
+	case p == nil:
+		t.polling = false
+	case p.ID != t.pollID:
+		t.pollID = p.ID
+		t.pollCursor = 0
+		t.polling = !t.client.Spectator() && !slices.Contains(p.Voters, t.client.User())
+	}
+}
+
+// updatePoll handles keys while the poll overlay is open.
+func (t *ttt) updatePoll(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	p := t.room.Poll
+	switch k := msg.String(); k {
+	case "ctrl+c":
+		return t, t.quit()
+	case "esc":
+		t.polling = false
+	case "up", "k":
+		t.pollCursor = max(t.pollCursor-1, 0)
+	case "down", "j":
+		t.pollCursor = min(t.pollCursor+1, len(p.Options)-1)
+	case "1", "2", "3", "4", "5", "6", "7", "8", "9":
+		if idx := int(k[0] - '1'); idx < len(p.Options) {
+			t.pollCursor = idx
+			t.votePoll()
+		}
+	case "enter":
+		t.votePoll()
+	}
+	return t, nil
+}
+
+func (t *ttt) votePoll() {
+	t.polling = false
+	if err := t.client.VotePoll(t.pollCursor); err != nil {
+		slog.Error("poll vote failed", "error", err)
+	}
+}
+
+// canVote reports whether this client still has a vote in the open poll.
+func (t *ttt) canVote() bool {
+	p := t.room.Poll
+	return p != nil && !t.client.Spectator() && !slices.Contains(p.Voters, t.client.User())
+}
+
+func pollTitle(p messages.Poll) string {
+	title := p.Question
+	if p.FistOfFive {
+		title = "✋ " + title
+	}
+	if p.Anonymous {
+		title += " (anonymous)"
+	}
+	return headerStyle.Render(title)
+}
+
+// renderPollOverlay lists the options to choose from.
+func renderPollOverlay(p messages.Poll, cursor int) string {
+	lines := []string{pollTitle(p), ""}
+	for idx, opt := range p.Options {
+		line := fmt.Sprintf("  %d. %s", idx+1, opt)
+		if idx == cursor {
+			line = pollCursorStyle.Render(fmt.Sprintf("> %d. %s", idx+1, opt))
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, "", "enter to vote, esc to decide later")
+	return pollStyle.Render(strings.Join(lines, "\n"))
+}
+
+// renderPollResults draws the live tallies as a bar chart.
+func renderPollResults(p messages.Poll) string {
+	var labelWidth int
+	for _, opt := range p.Options {
+		labelWidth = max(labelWidth, lipgloss.Width(opt))
+	}
+	total := max(len(p.Voters), 1)
+	lines := []string{pollTitle(p)}
+	for idx, opt := range p.Options {
+		n := p.Tallies[idx]
+		bar := pollBarStyle.Render(strings.Repeat("█", n*pollBarWidth/total))
+		line := fmt.Sprintf("%-*s %s %d", labelWidth, opt, bar, n)
+		if p.Choices != nil && len(p.Choices[idx]) > 0 {
+			line += " " + pollVotersStyle.Render(strings.Join(p.Choices[idx], ", "))
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, pollVotersStyle.Render(strconv.Itoa(len(p.Voters))+" voted"))
+	return pollStyle.Render(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/abennett/ttt/pkg/messages"
+)
+
+const (
+	// flashFrames is how many frames a row sparkles for after a reaction.
+	flashFrames   = 6
+	flashInterval = 250 * time.Millisecond
+)
+
+// flashMsg advances the reaction animation by one frame.
+type flashMsg struct{}
+
+var sparkles = []string{"✨", "💫"}
+
+func flashTick() tea.Cmd {
+	return tea.Tick(flashInterval, func(time.Time) tea.Msg {
+		return flashMsg{}
+	})
+}
+
+// kudos renders a participant's reactions, such as "👏2 🎉1".
+func kudos(rr messages.RollResult) string {
+	var parts []string
+	for _, emoji := range messages.Reactions {
+		if n := rr.Reactions[emoji]; n > 0 {
+			parts = append(parts, emoji+strconv.Itoa(n))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func totalReactions(rr messages.RollResult) int {
+	var total int
+	for _, n := range rr.Reactions {
+		total += n
+	}
+	return total
+}
+
+// noteReactions starts a flash for everyone who got a reaction since the
+// last state, returning the tick to drive it if one is not already running.
+func (t *ttt) noteReactions(next messages.RoomState) tea.Cmd {
+	before := make(map[string]int, len(t.room.Rolls))
+	for _, rr := range t.room.Rolls {
+		before[rr.User] = totalReactions(rr)
+	}
+	running := len(t.flashes) > 0
+	for _, rr := range next.Rolls {
+		if totalReactions(rr) > before[rr.User] {
+			t.flashes[rr.User] = flashFrames
+		}
+	}
+	if running || len(t.flashes) == 0 {
+		return nil
+	}
+	return flashTick()
+}
+
+// updateFlash moves every running flash on a frame.
+func (t *ttt) updateFlash() tea.Cmd {
+	for user, frames := range t.flashes {
+		if frames <= 1 {
+			delete(t.flashes, user)
+			continue
+		}
+		t.flashes[user] = frames - 1
+	}
+	t.refreshRows()
+	if len(t.flashes) == 0 {
+		return nil
+	}
+	return flashTick()
+}
+
+// react handles keys while picking a reaction for the selected row.
+func (t *ttt) react(k string) (bool, error) {
+	t.reacting = false
+	idx, err := strconv.Atoi(k)
+	if err != nil || idx < 1 || idx > len(messages.Reactions) {
+		return true, nil
+	}
+	rr, ok := t.selected()
+	if !ok {
+		return true, nil
+	}
+	return true, t.client.React(rr.User, messages.Reactions[idx-1])
+}
+
+func reactionPrompt() string {
+	parts := make([]string, len(messages.Reactions))
+	for idx, emoji := range messages.Reactions {
+		parts[idx] = strconv.Itoa(idx+1) + " " + emoji
+	}
+	return "react: " + strings.Join(parts, "  ") + "  (any other key cancels)"
+}
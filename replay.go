@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/table"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/abennett/ttt/pkg/server"
+)
+
+var replayStatusStyle = lipgloss.NewStyle().
+	Faint(true)
+
+// replayTick moves the replay on to frame next.
+type replayTick struct {
+	next int
+}
+
+// replayer plays back the frames of an event log.
+type replayer struct {
+	frames []server.ReplayFrame
+	idx    int
+	paused bool
+	speed  float64
+	maxGap time.Duration
+	table  table.Model
+}
+
+func newReplayer(frames []server.ReplayFrame, speed float64, maxGap time.Duration) *replayer {
+	t := table.New(
+		table.WithColumns(columns),
+		table.WithFocused(false),
+	)
+	s := table.DefaultStyles()
+	s.Header = s.Header.Foreground(lipgloss.Color("#01c5d1"))
+	s.Selected = lipgloss.NewStyle()
+	t.SetStyles(s)
+	r := &replayer{
+		frames: frames,
+		speed:  speed,
+		maxGap: maxGap,
+		table:  t,
+	}
+	r.show(0)
+	return r
+}
+
+func (r *replayer) show(idx int) {
+	r.idx = idx
+	rows, _ := tableRows(r.frames[idx].State, nil)
+	r.table.SetHeight(len(rows) + 1)
+	r.table.SetRows(rows)
+}
+
+// wait schedules the next frame after the time that passed between them in
+// the meeting, sped up and capped.
+func (r *replayer) wait() tea.Cmd {
+	next := r.idx + 1
+	if r.paused || next >= len(r.frames) {
+		return nil
+	}
+	gap := r.frames[next].At.Sub(r.frames[r.idx].At)
+	if r.maxGap > 0 {
+		gap = min(gap, r.maxGap)
+	}
+	gap = time.Duration(float64(gap) / r.speed)
+	return tea.Tick(gap, func(time.Time) tea.Msg {
+		return replayTick{next: next}
+	})
+}
+
+func (r *replayer) Init() tea.Cmd {
+	return r.wait()
+}
+
+func (r *replayer) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case replayTick:
+		// Ticks scheduled before a pause or a step are stale
+		if r.paused || msg.next != r.idx+1 {
+			return r, nil
+		}
+		r.show(msg.next)
+		return r, r.wait()
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "ctrl+c", "q":
+			return r, tea.Quit
+		case " ":
+			r.paused = !r.paused
+			return r, r.wait()
+		case "right", "l":
+			r.paused = true
+			r.show(min(r.idx+1, len(r.frames)-1))
+		case "left", "h":
+			r.paused = true
+			r.show(max(r.idx-1, 0))
+		case "home":
+			r.paused = true
+			r.show(0)
+		case "end":
+			r.paused = true
+			r.show(len(r.frames) - 1)
+		}
+	}
+	return r, nil
+}
+
+func (r *replayer) View() string {
+	frame := r.frames[r.idx]
+	var b strings.Builder
+	room := frame.State
+	b.WriteString(roomHeader(room) + "\n")
+	if agenda := renderAgenda(room); agenda != "" {
+		b.WriteString(agenda + "\n")
+	}
+	b.WriteString(baseStyle.Render(r.table.View()) + "\n")
+	if timer := renderTimebox(room, frame.At); timer != "" {
+		b.WriteString(timer + "\n")
+	}
+	if rr, ok := speaker(room.Rolls); ok && rr.Notes != nil {
+		b.WriteString(renderNotes(rr) + "\n")
+	}
+	if room.Pick != nil {
+		b.WriteString(renderPick(*room.Pick) + "\n")
+	}
+	if room.Estimate != nil {
+		b.WriteString(renderEstimate(*room.Estimate) + "\n")
+	}
+	if p := room.Poll; p != nil {
+		b.WriteString(renderPollResults(*p) + "\n")
+	}
+	if len(room.Chat) > 0 {
+		b.WriteString(renderChat(room.Chat) + "\n")
+	}
+	if blockers := renderBlockers(room.Rolls); blockers != "" {
+		b.WriteString(blockers + "\n")
+	}
+	state := "▶"
+	if r.paused {
+		state = "⏸"
+	}
+	status := fmt.Sprintf("%s %d/%d %s ×%g — space pause, ←/→ step, q quit",
+		state, r.idx+1, len(r.frames), frame.At.Local().Format("2006-01-02 15:04:05"), r.speed)
+	b.WriteString(replayStatusStyle.Render(status) + "\n")
+	return b.String()
+}
+
+func replayLog(_ context.Context, args []string) error {
+	if len(args) != 1 {
+		return flag.ErrHelp
+	}
+	if *replaySpeed <= 0 {
+		return fmt.Errorf("speed must be positive, got %g", *replaySpeed)
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close() //nolint: errcheck
+	frames, err := server.Replay(f)
+	if err != nil {
+		return err
+	}
+	_, err = tea.NewProgram(newReplayer(frames, *replaySpeed, *replayMaxGap)).Run()
+	return err
+}
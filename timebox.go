@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/abennett/ttt/pkg/messages"
+)
+
+// timeboxTick redraws the speaker's timer.
+type timeboxTick struct{}
+
+var timeboxStyle = lipgloss.NewStyle().
+	Faint(true)
+
+var overtimeStyle = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("#ff5f5f")).
+	Bold(true)
+
+// startTimer keeps the timebox ticking while the room has one.
+func (t *ttt) startTimer() tea.Cmd {
+	if t.timing || t.room.Timebox <= 0 {
+		return nil
+	}
+	t.timing = true
+	return timeboxTimer()
+}
+
+func (t *ttt) updateTimer() tea.Cmd {
+	if t.room.Timebox <= 0 {
+		t.timing = false
+		return nil
+	}
+	return timeboxTimer()
+}
+
+func timeboxTimer() tea.Cmd {
+	return tea.Tick(time.Second, func(time.Time) tea.Msg {
+		return timeboxTick{}
+	})
+}
+
+// clock formats d as minutes and seconds, such as "1:05".
+func clock(d time.Duration) string {
+	d = max(d, 0).Round(time.Second)
+	return fmt.Sprintf("%d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+}
+
+// renderTimebox shows how long the speaker has had against the timebox,
+// turning red once they run over.
+func renderTimebox(room messages.RoomState, now time.Time) string {
+	if room.Timebox <= 0 || room.TurnStarted.IsZero() {
+		return ""
+	}
+	rr, ok := speaker(room.Rolls)
+	if !ok {
+		return ""
+	}
+	elapsed := now.Sub(room.TurnStarted)
+	line := fmt.Sprintf("⏱ %s %s / %s", rr.User, clock(elapsed), clock(room.Timebox))
+	if elapsed > room.Timebox {
+		return overtimeStyle.Render(line + " over time")
+	}
+	return timeboxStyle.Render(line)
+}
@@ -1,15 +1,29 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
 	"io"
+	"net"
+	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/shoenig/test/must"
 	"github.com/shoenig/test/wait"
+	"github.com/vmihailenco/msgpack/v5"
 
 	"github.com/abennett/ttt/pkg/client"
+	"github.com/abennett/ttt/pkg/messages"
 	"github.com/abennett/ttt/pkg/server"
 )
 
@@ -27,24 +41,24 @@ func TestSingleClient(t *testing.T) {
 
 	must.MapContainsKey(t, srv.GetRooms(), "test1")
 	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
-		return len(client.Room.Rolls) > 0
+		return len(client.State().Rolls) > 0
 	})))
 
 	rooms := srv.GetRooms()
 	roomState := rooms["test1"]
-	must.Eq(t, roomState.Version, client.Room.Version)
+	must.Eq(t, roomState.Version, client.State().Version)
 	t.Log(roomState)
 
 	must.MapContainsKey(t, roomState.Rolls, "tester")
 	tester := roomState.Rolls["tester"]
-	must.False(t, tester.IsDone)
+	must.EqOp(t, messages.StatusWaiting, tester.Status)
 	must.NoError(t, client.ToggleDone())
 	time.Sleep(time.Second)
 	rooms = srv.GetRooms()
 	roomState = rooms["test1"]
 	must.MapContainsKey(t, roomState.Rolls, "tester")
 	tester = roomState.Rolls["tester"]
-	must.True(t, tester.IsDone)
+	must.EqOp(t, messages.StatusDone, tester.Status)
 	must.EqOp(t, 0, tester.ID)
 
 	err = client.Close()
@@ -67,16 +81,19 @@ func TestMultipleClients(t *testing.T) {
 
 	err = client1.Init()
 	must.NoError(t, err)
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return client1.State().Version == 1
+	})))
 
 	err = client2.Init()
 	must.NoError(t, err)
 
 	must.MapContainsKey(t, srv.GetRooms(), "test1")
 	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
-		return client1.Room.Version == 2
+		return client1.State().Version == 2
 	})))
 	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
-		return client2.Room.Version == 2
+		return client2.State().Version == 2
 	})))
 
 	rooms := srv.GetRooms()
@@ -86,3 +103,1826 @@ func TestMultipleClients(t *testing.T) {
 	tester2 := roomState.Rolls["tester2"]
 	must.Eq(t, 1, tester2.ID)
 }
+
+func TestParticipantStatus(t *testing.T) {
+	t.Parallel()
+	srv := server.NewServer()
+	mux := server.NewMux(srv)
+	testSrv := httptest.NewServer(mux)
+
+	host, err := client.New(testSrv.URL, "test1", "host", io.Discard)
+	must.NoError(t, err)
+	must.NoError(t, host.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return host.State().Version == 1
+	})))
+
+	guest, err := client.New(testSrv.URL, "test1", "guest", io.Discard)
+	must.NoError(t, err)
+	must.NoError(t, guest.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return guest.State().Version == 2
+	})))
+	must.EqOp(t, "host", guest.State().Host)
+
+	// Only the host may change someone else's status
+	must.NoError(t, guest.SetStatus("host", messages.StatusAbsent))
+	must.NoError(t, guest.SetStatus("", messages.StatusDeferred))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 3
+	})))
+	must.NoError(t, host.SetStatus("guest", messages.StatusAbsent))
+	must.NoError(t, host.SetStatus("", messages.StatusPassed))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 5
+	})))
+
+	// Queued is the server's to set, and made-up statuses are refused
+	must.NoError(t, guest.SetStatus("", messages.StatusQueued))
+	must.NoError(t, guest.SetStatus("", messages.Status(99)))
+	must.NoError(t, host.SetStatus("guest", messages.Status(99)))
+	must.NoError(t, host.SetStatus("guest", messages.StatusAbsent))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 6
+	})))
+
+	room, err := srv.GetRoom("test1")
+	must.NoError(t, err)
+	state := room.State()
+	must.SliceLen(t, 2, state.Rolls)
+	must.EqOp(t, "host", state.Rolls[0].User)
+	must.EqOp(t, messages.StatusPassed, state.Rolls[0].Status)
+	must.EqOp(t, "guest", state.Rolls[1].User)
+	must.EqOp(t, messages.StatusAbsent, state.Rolls[1].Status)
+}
+
+func TestRoomOptions(t *testing.T) {
+	t.Parallel()
+	srv := server.NewServer()
+	mux := server.NewMux(srv)
+	testSrv := httptest.NewServer(mux)
+
+	_, err := client.New(testSrv.URL, "test1", "tester", io.Discard,
+		client.WithRoomOptions(messages.RoomOptions{TieBreak: "coinflip"}))
+	must.Error(t, err)
+	_, err = client.New(testSrv.URL, "test1", "tester", io.Discard,
+		client.WithRoomOptions(messages.RoomOptions{Ordering: "sideways"}))
+	must.Error(t, err)
+	for _, dice := range []string{"1d0", "0d6", "999999999d6", "1d999999999"} {
+		_, err = client.New(testSrv.URL, "test1", "tester", io.Discard,
+			client.WithRoomOptions(messages.RoomOptions{Dice: dice}))
+		must.ErrorIs(t, err, client.ErrRejected)
+		must.StrContains(t, err.Error(), "400")
+	}
+	must.MapEmpty(t, srv.GetRooms())
+
+	c, err := client.New(testSrv.URL, "test1", "tester", io.Discard,
+		client.WithModifier(3),
+		client.WithRoomOptions(messages.RoomOptions{Ordering: "lowest", TieBreak: "rolloff"}))
+	must.NoError(t, err)
+	must.NoError(t, c.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return c.State().Version == 1
+	})))
+	must.EqOp(t, "lowest", c.State().Ordering)
+	must.EqOp(t, "rolloff", c.State().TieBreak)
+	must.EqOp(t, 3, c.State().Rolls[0].Modifier)
+	// The modifier only settles ties, so never changes the roll
+	must.Between(t, 1, c.State().Rolls[0].Result, 20)
+}
+
+// player is someone joining a room in a test.
+type player struct {
+	name     string
+	modifier int
+}
+
+// joinRoom joins each player to room test1 in turn, creating it with opts
+// if needed, and waits until they are all in.
+func joinRoom(t *testing.T, srv *server.Server, opts messages.RoomOptions, players ...player) []*client.Client {
+	t.Helper()
+	testSrv := httptest.NewServer(server.NewMux(srv))
+	t.Cleanup(testSrv.Close)
+
+	clients := make([]*client.Client, len(players))
+	for idx, p := range players {
+		joined := srv.GetRooms()["test1"].Version + 1
+		c, err := client.New(testSrv.URL, "test1", p.name, io.Discard,
+			client.WithModifier(p.modifier),
+			client.WithRoomOptions(opts))
+		must.NoError(t, err)
+		must.NoError(t, c.Init())
+		must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+			return srv.GetRooms()["test1"].Version == joined
+		})))
+		clients[idx] = c
+	}
+	return clients
+}
+
+// turnOrder lists the room's participants in turn order.
+func turnOrder(t *testing.T, srv *server.Server) []string {
+	t.Helper()
+	room, err := srv.GetRoom("test1")
+	must.NoError(t, err)
+	var order []string
+	for _, rr := range room.State().Rolls {
+		order = append(order, rr.User)
+	}
+	return order
+}
+
+func TestTieBreaks(t *testing.T) {
+	t.Parallel()
+	// Everyone rolls 1 on 1d1, so every result is tied
+	players := []player{{name: "carol", modifier: 1}, {name: "alice"}, {name: "bob", modifier: 2}}
+
+	for _, tc := range []struct {
+		tieBreak string
+		want     []string
+	}{
+		{tieBreak: "join", want: []string{"carol", "alice", "bob"}},
+		{tieBreak: "alpha", want: []string{"alice", "bob", "carol"}},
+		{tieBreak: "modifier", want: []string{"bob", "carol", "alice"}},
+	} {
+		t.Run(tc.tieBreak, func(t *testing.T) {
+			t.Parallel()
+			srv := server.NewServer()
+			joinRoom(t, srv, messages.RoomOptions{Dice: "1d1", TieBreak: tc.tieBreak}, players...)
+			must.Eq(t, tc.want, turnOrder(t, srv))
+		})
+	}
+
+	t.Run("rolloff", func(t *testing.T) {
+		t.Parallel()
+		srv := server.NewServer()
+		joinRoom(t, srv, messages.RoomOptions{Dice: "1d1", TieBreak: "rolloff"}, players...)
+		room, err := srv.GetRoom("test1")
+		must.NoError(t, err)
+		rolls := room.State().Rolls
+		must.SliceLen(t, 3, rolls)
+		for idx, rr := range rolls {
+			must.EqOp(t, 1, rr.Result)
+			must.Between(t, 1, rr.RollOff, 20)
+			if idx > 0 {
+				// Higher roll-offs go first, and a tied roll-off falls back
+				// to join order
+				prev := rolls[idx-1]
+				must.True(t, prev.RollOff > rr.RollOff || prev.RollOff == rr.RollOff && prev.ID < rr.ID)
+			}
+		}
+	})
+
+	t.Run("modifier only settles ties", func(t *testing.T) {
+		t.Parallel()
+		srv := server.NewServer()
+		joinRoom(t, srv, messages.RoomOptions{Dice: "1d1000", TieBreak: "modifier"},
+			player{name: "alice", modifier: 1000}, player{name: "bob"}, player{name: "carol"})
+		room, err := srv.GetRoom("test1")
+		must.NoError(t, err)
+		rolls := room.State().Rolls
+		for idx, rr := range rolls {
+			must.Between(t, 1, rr.Result, 1000)
+			if idx > 0 {
+				prev := rolls[idx-1]
+				must.True(t, prev.Result > rr.Result || prev.Result == rr.Result && prev.Modifier >= rr.Modifier)
+			}
+		}
+	})
+}
+
+func TestOrderings(t *testing.T) {
+	t.Parallel()
+	players := []player{{name: "carol"}, {name: "alice"}, {name: "bob"}, {name: "dave"}}
+
+	for _, tc := range []struct {
+		ordering string
+		// before reports whether a is ordered before b.
+		before func(a, b messages.RollResult) bool
+		// doneLast is set for orderings that sink those who have finished.
+		doneLast bool
+	}{
+		{
+			ordering: "highest",
+			before: func(a, b messages.RollResult) bool {
+				return a.Result > b.Result || a.Result == b.Result && a.ID < b.ID
+			},
+		},
+		{
+			ordering: "lowest",
+			before: func(a, b messages.RollResult) bool {
+				return a.Result < b.Result || a.Result == b.Result && a.ID < b.ID
+			},
+		},
+		{
+			ordering: "join",
+			before: func(a, b messages.RollResult) bool {
+				return a.ID < b.ID
+			},
+		},
+		{
+			ordering: "alpha",
+			before: func(a, b messages.RollResult) bool {
+				return a.User < b.User
+			},
+		},
+		{
+			ordering: "donelast",
+			before: func(a, b messages.RollResult) bool {
+				return a.Result > b.Result || a.Result == b.Result && a.ID < b.ID
+			},
+			doneLast: true,
+		},
+	} {
+		t.Run(tc.ordering, func(t *testing.T) {
+			t.Parallel()
+			srv := server.NewServer()
+			clients := joinRoom(t, srv, messages.RoomOptions{Dice: "1d1000", Ordering: tc.ordering}, players...)
+			room, err := srv.GetRoom("test1")
+			must.NoError(t, err)
+			inOrder := func(rolls []messages.RollResult) {
+				t.Helper()
+				for idx := 1; idx < len(rolls); idx++ {
+					must.True(t, tc.before(rolls[idx-1], rolls[idx]),
+						must.Sprintf("%s before %s", rolls[idx-1].User, rolls[idx].User))
+				}
+			}
+			rolls := room.State().Rolls
+			must.SliceLen(t, 4, rolls)
+			inOrder(rolls)
+
+			// Whoever has finished moves to the top, or to the bottom
+			must.NoError(t, clients[0].ToggleDone())
+			must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+				return srv.GetRooms()["test1"].Version == 5
+			})))
+			rolls = room.State().Rolls
+			if tc.doneLast {
+				must.EqOp(t, "carol", rolls[3].User)
+				inOrder(rolls[:3])
+			} else {
+				must.EqOp(t, "carol", rolls[0].User)
+				inOrder(rolls[1:])
+			}
+		})
+	}
+
+	t.Run("shuffle", func(t *testing.T) {
+		t.Parallel()
+		srv := server.NewServer()
+		clients := joinRoom(t, srv, messages.RoomOptions{Ordering: "shuffle"}, players...)
+		order := turnOrder(t, srv)
+		must.SliceContainsAll(t, []string{"alice", "bob", "carol", "dave"}, order)
+
+		// The order holds still between updates
+		must.NoError(t, clients[1].SendChat("hello"))
+		must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+			return srv.GetRooms()["test1"].Version == 5
+		})))
+		must.Eq(t, order, turnOrder(t, srv))
+	})
+}
+
+func TestManualOrder(t *testing.T) {
+	t.Parallel()
+	srv := server.NewServer()
+	mux := server.NewMux(srv)
+	testSrv := httptest.NewServer(mux)
+
+	host, err := client.New(testSrv.URL, "test1", "host", io.Discard)
+	must.NoError(t, err)
+	must.NoError(t, host.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return host.State().Version == 1
+	})))
+
+	guest, err := client.New(testSrv.URL, "test1", "guest", io.Discard)
+	must.NoError(t, err)
+	must.NoError(t, guest.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 2
+	})))
+
+	room, err := srv.GetRoom("test1")
+	must.NoError(t, err)
+
+	// Only the host may reorder
+	must.NoError(t, guest.MoveUser("guest", messages.MoveToTop, 0))
+	must.NoError(t, host.MoveUser("guest", messages.MoveToTop, 0))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 3
+	})))
+	state := room.State()
+	must.True(t, state.ManualOrder)
+	must.EqOp(t, "guest", state.Rolls[0].User)
+	must.EqOp(t, "host", state.Rolls[1].User)
+
+	must.NoError(t, host.MoveUser("guest", messages.MoveDown, 0))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 4
+	})))
+	state = room.State()
+	must.EqOp(t, "host", state.Rolls[0].User)
+	must.EqOp(t, "guest", state.Rolls[1].User)
+
+	must.NoError(t, host.MoveUser("", messages.MoveReset, 0))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 5
+	})))
+	must.False(t, room.State().ManualOrder)
+}
+
+func TestLateJoin(t *testing.T) {
+	t.Parallel()
+	srv := server.NewServer()
+	clients := joinRoom(t, srv, messages.RoomOptions{LateJoin: "queue"},
+		player{name: "host"}, player{name: "early"})
+	host, early := clients[0], clients[1]
+	must.False(t, host.State().Rolls[0].Late)
+	must.NoError(t, host.ToggleDone())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 3
+	})))
+
+	late := joinRoom(t, srv, messages.RoomOptions{}, player{name: "late"})[0]
+	must.EqOp(t, "queue", late.State().LateJoin)
+	room, err := srv.GetRoom("test1")
+	must.NoError(t, err)
+	latecomer := room.State().Rolls[2]
+	must.EqOp(t, "late", latecomer.User)
+	must.True(t, latecomer.Late)
+	must.EqOp(t, messages.StatusQueued, latecomer.Status)
+	must.False(t, latecomer.JoinedAt.IsZero())
+
+	// The queue can't be skipped, but is let in once the round is over
+	must.NoError(t, late.ToggleDone())
+	must.NoError(t, late.SetStatus("", messages.StatusPassed))
+	must.NoError(t, late.SendChat("sorry I'm late"))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 5
+	})))
+	must.EqOp(t, messages.StatusQueued, room.State().Rolls[2].Status)
+	must.NoError(t, early.ToggleDone())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 6
+	})))
+	latecomer = room.State().Rolls[2]
+	must.EqOp(t, "late", latecomer.User)
+	must.EqOp(t, messages.StatusWaiting, latecomer.Status)
+}
+
+func TestRoomPassword(t *testing.T) {
+	t.Parallel()
+	srv := server.NewServer()
+	mux := server.NewMux(srv)
+	testSrv := httptest.NewServer(mux)
+
+	_, err := client.New(testSrv.URL, "locked", "host", io.Discard,
+		client.WithPassword("hunter2"))
+	must.NoError(t, err)
+
+	_, err = client.New(testSrv.URL, "locked", "guest", io.Discard)
+	must.ErrorIs(t, err, client.ErrRejected)
+	must.StrContains(t, err.Error(), "401")
+
+	_, err = client.New(testSrv.URL, "locked", "guest", io.Discard,
+		client.WithPassword("hunter3"))
+	must.ErrorIs(t, err, client.ErrRejected)
+	must.StrContains(t, err.Error(), "403")
+
+	_, err = client.New(testSrv.URL, "locked", "guest", io.Discard,
+		client.WithPassword("hunter2"))
+	must.NoError(t, err)
+
+	host, err := client.New(testSrv.URL, "coded", "host", io.Discard,
+		client.WithRoomOptions(messages.RoomOptions{JoinCode: true}))
+	must.NoError(t, err)
+	must.NoError(t, host.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return host.State().Version == 1
+	})))
+	must.True(t, host.State().Protected)
+	must.EqOp(t, 6, len(host.State().JoinCode))
+
+	_, err = client.New(testSrv.URL, "coded", "guest", io.Discard)
+	must.ErrorIs(t, err, client.ErrRejected)
+	_, err = client.New(testSrv.URL, "coded", "guest", io.Discard,
+		client.WithPassword(host.State().JoinCode))
+	must.NoError(t, err)
+}
+
+func TestRoomCapacity(t *testing.T) {
+	t.Parallel()
+	srv := server.NewServer()
+	mux := server.NewMux(srv)
+	testSrv := httptest.NewServer(mux)
+
+	host, err := client.New(testSrv.URL, "test1", "host", io.Discard,
+		client.WithRoomOptions(messages.RoomOptions{Capacity: 2}))
+	must.NoError(t, err)
+	must.NoError(t, host.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return host.State().Version == 1
+	})))
+	must.EqOp(t, 2, host.State().Capacity)
+
+	must.NoError(t, host.SetLocked(true))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 2
+	})))
+	_, err = client.New(testSrv.URL, "test1", "guest1", io.Discard)
+	must.ErrorIs(t, err, client.ErrRejected)
+	must.StrContains(t, err.Error(), "locked")
+
+	must.NoError(t, host.SetLocked(false))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 3
+	})))
+	guest, err := client.New(testSrv.URL, "test1", "guest1", io.Discard)
+	must.NoError(t, err)
+	must.NoError(t, guest.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 4
+	})))
+
+	_, err = client.New(testSrv.URL, "test1", "guest2", io.Discard)
+	must.ErrorIs(t, err, client.ErrRejected)
+	must.StrContains(t, err.Error(), "full")
+
+	// Leaving for good gives up the place
+	must.NoError(t, guest.Close())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 5
+	})))
+	_, err = client.New(testSrv.URL, "test1", "guest2", io.Discard)
+	must.NoError(t, err)
+}
+
+func TestRoomCapacityRace(t *testing.T) {
+	t.Parallel()
+	srv := server.NewServer()
+	testSrv := httptest.NewServer(server.NewMux(srv))
+
+	host, err := client.New(testSrv.URL, "test1", "host", io.Discard,
+		client.WithRoomOptions(messages.RoomOptions{Capacity: 2}))
+	must.NoError(t, err)
+	must.NoError(t, host.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return host.State().Version == 1
+	})))
+
+	// Everyone gets through the door before anyone asks for a place, then
+	// they all try for the last one at once and only one gets it
+	guests := make([]*client.Client, 16)
+	for n := range guests {
+		guests[n], err = client.New(testSrv.URL, "test1", fmt.Sprintf("guest%d", n), io.Discard)
+		must.NoError(t, err)
+	}
+	var (
+		wg       sync.WaitGroup
+		admitted atomic.Int32
+	)
+	for _, guest := range guests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if guest.Init() != nil {
+				return
+			}
+			if _, ok := guest.ReadUpdate().(messages.RoomState); ok {
+				admitted.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	must.EqOp(t, 1, admitted.Load())
+	must.MapLen(t, 2, srv.GetRooms()["test1"].Rolls)
+}
+
+func TestDuplicateUsers(t *testing.T) {
+	t.Parallel()
+
+	join := func(t *testing.T, url string) *client.Client {
+		c, err := client.New(url, "test1", "alice", io.Discard)
+		must.NoError(t, err)
+		must.NoError(t, c.Init())
+		return c
+	}
+
+	t.Run("reject", func(t *testing.T) {
+		testSrv := httptest.NewServer(server.NewMux(server.NewServer()))
+		first := join(t, testSrv.URL)
+		must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+			return first.State().Version == 1
+		})))
+		second := join(t, testSrv.URL)
+		msg := second.ReadUpdate()
+		errMsg, ok := msg.(messages.ErrorMessage)
+		must.True(t, ok)
+		must.EqOp(t, messages.ErrCodeDuplicateUser, errMsg.Code)
+	})
+
+	t.Run("suffix", func(t *testing.T) {
+		srv := server.NewServer(server.WithDuplicatePolicy(server.DuplicateSuffix))
+		testSrv := httptest.NewServer(server.NewMux(srv))
+		first := join(t, testSrv.URL)
+		must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+			return first.State().Version == 1
+		})))
+		second := join(t, testSrv.URL)
+		must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+			return second.User() == "alice#2"
+		})))
+		must.EqOp(t, "alice", first.User())
+		must.MapLen(t, 2, srv.GetRooms()["test1"].Rolls)
+	})
+
+	t.Run("share", func(t *testing.T) {
+		srv := server.NewServer(server.WithDuplicatePolicy(server.DuplicateShare))
+		testSrv := httptest.NewServer(server.NewMux(srv))
+		first := join(t, testSrv.URL)
+		must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+			return first.State().Version == 1
+		})))
+		second := join(t, testSrv.URL)
+		must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+			return second.State().Version == 2
+		})))
+		must.EqOp(t, "alice", second.User())
+		must.MapLen(t, 1, srv.GetRooms()["test1"].Rolls)
+		must.EqOp(t, first.State().Rolls[0].Result, second.State().Rolls[0].Result)
+	})
+}
+
+// cuttableServer serves srv, returning a function that drops every
+// WebSocket connection without a close message.
+func cuttableServer(t *testing.T, srv *server.Server) (*httptest.Server, func()) {
+	t.Helper()
+	testSrv := httptest.NewUnstartedServer(server.NewMux(srv))
+	t.Cleanup(testSrv.Close)
+
+	// Keep hold of upgraded connections so they can be cut
+	var mu sync.Mutex
+	var conns []net.Conn
+	testSrv.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateHijacked {
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}
+	testSrv.Start()
+	return testSrv, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			must.NoError(t, conn.Close())
+		}
+		conns = nil
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	t.Parallel()
+	srv := server.NewServer(server.WithGracePeriod(10 * time.Second))
+	testSrv, cut := cuttableServer(t, srv)
+
+	c, err := client.New(testSrv.URL, "test1", "tester", io.Discard)
+	must.NoError(t, err)
+	must.NoError(t, c.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return c.State().Version == 1
+	})))
+	must.NotEq(t, "", c.State().ResumeToken)
+	before := *srv.GetRooms()["test1"].Rolls["tester"]
+
+	// A held place can be reclaimed even once the room is locked
+	must.NoError(t, c.SetLocked(true))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return c.State().Version == 2
+	})))
+	// Nothing else reads the client's updates, so clear them for it to
+	// notice the drop
+	c.ReadUpdate()
+	c.ReadUpdate()
+
+	cut()
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		room, err := srv.GetRoom("test1")
+		return err == nil && room.State().Rolls[0].Disconnected
+	})))
+
+	must.Wait(t, wait.InitialSuccess(
+		wait.BoolFunc(func() bool {
+			room, err := srv.GetRoom("test1")
+			return err == nil && room.State().Version == 4 && !room.State().Rolls[0].Disconnected
+		}),
+		wait.Timeout(5*time.Second),
+	))
+	after := srv.GetRooms()["test1"].Rolls["tester"]
+	must.EqOp(t, before.Result, after.Result)
+	must.EqOp(t, before.ID, after.ID)
+}
+
+func TestHeldPlace(t *testing.T) {
+	t.Parallel()
+	srv := server.NewServer(
+		server.WithGracePeriod(10*time.Second),
+		server.WithDuplicatePolicy(server.DuplicateSuffix))
+	testSrv, cut := cuttableServer(t, srv)
+
+	alice, err := client.New(testSrv.URL, "test1", "alice", io.Discard)
+	must.NoError(t, err)
+	must.NoError(t, alice.Init())
+	// With an update no one reads, alice's client stops reading and won't
+	// come back by itself
+	must.NoError(t, alice.SendChat("back in a minute"))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return alice.State().Version == 2
+	})))
+	cut()
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 3
+	})))
+
+	// Giving the same name without the resume token doesn't take her place
+	impostor, err := client.New(testSrv.URL, "test1", "alice", io.Discard)
+	must.NoError(t, err)
+	must.NoError(t, impostor.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return impostor.User() == "alice#2"
+	})))
+	rolls := srv.GetRooms()["test1"].Rolls
+	must.MapLen(t, 2, rolls)
+	must.True(t, rolls["alice"].Disconnected)
+}
+
+func TestSpectator(t *testing.T) {
+	t.Parallel()
+	srv := server.NewServer()
+	mux := server.NewMux(srv)
+	testSrv := httptest.NewServer(mux)
+
+	player, err := client.New(testSrv.URL, "test1", "player", io.Discard)
+	must.NoError(t, err)
+	must.NoError(t, player.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return player.State().Version == 1
+	})))
+
+	// Spectators take no place, so can watch a locked room
+	must.NoError(t, player.SetLocked(true))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 2
+	})))
+	watcher, err := client.New(testSrv.URL, "test1", "", io.Discard, client.AsSpectator())
+	must.NoError(t, err)
+	must.NoError(t, watcher.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return watcher.State().Version == 3
+	})))
+	must.EqOp(t, 1, watcher.State().Spectators)
+	must.SliceLen(t, 1, watcher.State().Rolls)
+	must.EqOp(t, "", watcher.State().You)
+	must.EqOp(t, "", watcher.State().ResumeToken)
+
+	// Claiming to be a spectator doesn't get a place in a locked room
+	conn, _, err := websocket.DefaultDialer.Dial(
+		"ws"+strings.TrimPrefix(testSrv.URL, "http")+"/test1",
+		http.Header{messages.WatchHeader: {"1"}})
+	must.NoError(t, err)
+	defer conn.Close()
+	b, err := msgpack.Marshal(messages.Message{
+		Type:    messages.RollRequestType,
+		Version: "1",
+		Payload: messages.RollRequest{User: "sneaky"},
+	})
+	must.NoError(t, err)
+	must.NoError(t, conn.WriteMessage(websocket.BinaryMessage, b))
+	_, b, err = conn.ReadMessage()
+	must.NoError(t, err)
+	var refused messages.Message
+	must.NoError(t, msgpack.Unmarshal(b, &refused))
+	must.Eq[any](t, messages.ErrorMessage{
+		Code:    messages.ErrCodeRoomFull,
+		Message: server.ErrRoomLocked.Error(),
+	}, refused.Payload)
+
+	// Spectators cannot act on the room
+	must.NoError(t, watcher.SetStatus("player", messages.StatusAbsent))
+	must.NoError(t, watcher.Close())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 4
+	})))
+	room, err := srv.GetRoom("test1")
+	must.NoError(t, err)
+	state := room.State()
+	must.EqOp(t, 0, state.Spectators)
+	must.EqOp(t, messages.StatusWaiting, state.Rolls[0].Status)
+}
+
+func TestChat(t *testing.T) {
+	t.Parallel()
+	srv := server.NewServer()
+	mux := server.NewMux(srv)
+	testSrv := httptest.NewServer(mux)
+
+	c, err := client.New(testSrv.URL, "test1", "tester", io.Discard)
+	must.NoError(t, err)
+	must.NoError(t, c.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return c.State().Version == 1
+	})))
+
+	must.NoError(t, c.SendChat("   "))
+	must.NoError(t, c.SendChat(" I'll go after Bob "))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 2
+	})))
+	room, err := srv.GetRoom("test1")
+	must.NoError(t, err)
+	chat := room.State().Chat
+	must.SliceLen(t, 1, chat)
+	must.EqOp(t, "tester", chat[0].User)
+	must.EqOp(t, "I'll go after Bob", chat[0].Text)
+	must.False(t, chat[0].At.IsZero())
+
+	for range server.ChatHistory + 5 {
+		must.NoError(t, c.SendChat("spam"))
+	}
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == server.ChatHistory+7
+	})))
+	must.SliceLen(t, server.ChatHistory, room.State().Chat)
+}
+
+func TestReactions(t *testing.T) {
+	t.Parallel()
+	srv := server.NewServer()
+	mux := server.NewMux(srv)
+	testSrv := httptest.NewServer(mux)
+
+	alice, err := client.New(testSrv.URL, "test1", "alice", io.Discard)
+	must.NoError(t, err)
+	must.NoError(t, alice.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return alice.State().Version == 1
+	})))
+	bob, err := client.New(testSrv.URL, "test1", "bob", io.Discard)
+	must.NoError(t, err)
+	must.NoError(t, bob.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 2
+	})))
+
+	// Reacting to yourself or with an unknown emoji is ignored
+	must.NoError(t, alice.React("alice", "👏"))
+	must.NoError(t, alice.React("bob", "💩"))
+	must.NoError(t, alice.React("bob", "🎉"))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 3
+	})))
+
+	// Only ReactionLimit get through before the rate limit applies
+	for range server.ReactionLimit + 3 {
+		must.NoError(t, alice.React("bob", "👏"))
+	}
+	must.NoError(t, alice.SendChat("done"))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == server.ReactionLimit+3
+	})))
+
+	rooms := srv.GetRooms()
+	must.MapEmpty(t, rooms["test1"].Rolls["alice"].Reactions)
+	must.Eq(t, map[string]int{
+		"🎉": 1,
+		"👏": server.ReactionLimit - 1,
+	}, rooms["test1"].Rolls["bob"].Reactions)
+}
+
+func TestPlanningPoker(t *testing.T) {
+	t.Parallel()
+	srv := server.NewServer()
+	mux := server.NewMux(srv)
+	testSrv := httptest.NewServer(mux)
+
+	alice, err := client.New(testSrv.URL, "test1", "alice", io.Discard,
+		client.WithRoomOptions(messages.RoomOptions{Mode: "poker"}),
+	)
+	must.NoError(t, err)
+	must.NoError(t, alice.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return alice.State().Version == 1
+	})))
+	must.Eq(t, []string{"0", "1", "2", "3", "5", "8", "13", "21", "?"}, alice.State().Deck)
+	bob, err := client.New(testSrv.URL, "test1", "bob", io.Discard)
+	must.NoError(t, err)
+	must.NoError(t, bob.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 2
+	})))
+
+	// Cards outside the deck, and reveals by anyone but the host, are ignored
+	must.NoError(t, alice.Vote("4"))
+	must.NoError(t, bob.Reveal(true))
+	must.NoError(t, alice.Vote("5"))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 3
+	})))
+	must.NoError(t, bob.Vote("8"))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 4
+	})))
+
+	room, err := srv.GetRoom("test1")
+	must.NoError(t, err)
+	state := room.State()
+	must.False(t, state.Revealed)
+	must.Nil(t, state.Estimate)
+	for _, rr := range state.Rolls {
+		must.True(t, rr.Voted)
+		must.EqOp(t, "", rr.Vote)
+	}
+
+	must.NoError(t, alice.Reveal(true))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 5
+	})))
+	state = room.State()
+	must.True(t, state.Revealed)
+	votes := map[string]string{}
+	for _, rr := range state.Rolls {
+		votes[rr.User] = rr.Vote
+	}
+	must.Eq(t, map[string]string{"alice": "5", "bob": "8"}, votes)
+	must.NotNil(t, state.Estimate)
+	must.EqOp(t, 2, state.Estimate.Votes)
+	must.EqOp(t, 6.5, *state.Estimate.Average)
+	must.EqOp(t, "5", state.Estimate.Median)
+	must.False(t, state.Estimate.Consensus)
+
+	// Voting is closed until the host clears the table for the next item
+	must.NoError(t, bob.Vote("5"))
+	must.NoError(t, bob.SendChat("oops"))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 6
+	})))
+	for _, rr := range room.State().Rolls {
+		must.EqOp(t, votes[rr.User], rr.Vote)
+	}
+	must.NoError(t, alice.Reveal(false))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 7
+	})))
+	state = room.State()
+	must.False(t, state.Revealed)
+	for _, rr := range state.Rolls {
+		must.False(t, rr.Voted)
+	}
+}
+
+func TestPolls(t *testing.T) {
+	t.Parallel()
+	srv := server.NewServer()
+	mux := server.NewMux(srv)
+	testSrv := httptest.NewServer(mux)
+
+	alice, err := client.New(testSrv.URL, "test1", "alice", io.Discard)
+	must.NoError(t, err)
+	must.NoError(t, alice.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return alice.State().Version == 1
+	})))
+	bob, err := client.New(testSrv.URL, "test1", "bob", io.Discard)
+	must.NoError(t, err)
+	must.NoError(t, bob.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 2
+	})))
+
+	// Only the host can ask, and a poll needs a real choice
+	must.NoError(t, bob.AskPoll(messages.PollRequest{Question: "Skip standup?", Options: []string{"yes", "no"}}))
+	must.NoError(t, alice.AskPoll(messages.PollRequest{Question: "Lunch?", Options: []string{"pizza", " "}}))
+	must.NoError(t, alice.AskPoll(messages.PollRequest{Question: "Lunch?", Options: []string{"pizza", "tacos"}}))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 3
+	})))
+
+	must.NoError(t, bob.VotePoll(1))
+	must.NoError(t, bob.VotePoll(0))
+	must.NoError(t, alice.VotePoll(1))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 5
+	})))
+	room, err := srv.GetRoom("test1")
+	must.NoError(t, err)
+	poll := room.State().Poll
+	must.NotNil(t, poll)
+	must.EqOp(t, "Lunch?", poll.Question)
+	must.Eq(t, []int{0, 2}, poll.Tallies)
+	must.Eq(t, []string{"alice", "bob"}, poll.Voters)
+	must.Eq(t, []string{"alice", "bob"}, poll.Choices[1])
+
+	must.NoError(t, alice.AskPoll(messages.PollRequest{
+		Question:   "How confident are we?",
+		FistOfFive: true,
+		Anonymous:  true,
+	}))
+	must.NoError(t, alice.VotePoll(4))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 7
+	})))
+	next := room.State().Poll
+	must.NotEq(t, poll.ID, next.ID)
+	must.Eq(t, []string{"1", "2", "3", "4", "5"}, next.Options)
+	must.Eq(t, []int{0, 0, 0, 0, 1}, next.Tallies)
+	must.Nil(t, next.Choices)
+
+	must.NoError(t, alice.ClosePoll())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 8
+	})))
+	must.Nil(t, room.State().Poll)
+}
+
+func TestPick(t *testing.T) {
+	t.Parallel()
+	srv := server.NewServer()
+	mux := server.NewMux(srv)
+	testSrv := httptest.NewServer(mux)
+
+	alice, err := client.New(testSrv.URL, "test1", "alice", io.Discard)
+	must.NoError(t, err)
+	must.NoError(t, alice.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return alice.State().Version == 1
+	})))
+	bob, err := client.New(testSrv.URL, "test1", "bob", io.Discard)
+	must.NoError(t, err)
+	must.NoError(t, bob.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 2
+	})))
+
+	// Only the host picks, and only from those present
+	must.NoError(t, bob.Pick(1, "note-taker"))
+	must.NoError(t, alice.Pick(3, "note-taker"))
+	must.NoError(t, alice.Pick(2, "facilitator"))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 3
+	})))
+	room, err := srv.GetRoom("test1")
+	must.NoError(t, err)
+	pick := room.State().Pick
+	must.NotNil(t, pick)
+	must.EqOp(t, "facilitator", pick.Role)
+	must.SliceContainsAll(t, []string{"alice", "bob"}, pick.Users)
+
+	must.NoError(t, alice.Pick(0, ""))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 4
+	})))
+	must.SliceLen(t, 1, room.State().Pick.Users)
+
+	// The history outlives the room
+	must.NoError(t, bob.Close())
+	must.NoError(t, alice.Close())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		_, err := srv.GetRoom("test1")
+		return err != nil
+	})))
+	history := srv.PickHistory("test1")
+	must.EqOp(t, 3, history["alice"]+history["bob"])
+}
+
+func TestPickSkipsLeavers(t *testing.T) {
+	t.Parallel()
+	srv := server.NewServer()
+	clients := joinRoom(t, srv, messages.RoomOptions{},
+		player{name: "alice"}, player{name: "bob"}, player{name: "carol"})
+	alice := clients[0]
+
+	// carol's row stays after leaving, but can't be picked
+	must.NoError(t, clients[2].Close())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 4
+	})))
+	must.MapContainsKey(t, srv.GetRooms()["test1"].Rolls, "carol")
+
+	room, err := srv.GetRoom("test1")
+	must.NoError(t, err)
+	for n := range 10 {
+		must.NoError(t, alice.Pick(2, "reviewer"))
+		must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+			return srv.GetRooms()["test1"].Version == 5+n
+		})))
+		must.SliceNotContains(t, room.State().Pick.Users, "carol")
+	}
+	must.NoError(t, alice.Pick(3, "reviewer"))
+	must.NoError(t, alice.Pick(1, "reviewer"))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 15
+	})))
+	must.SliceLen(t, 1, room.State().Pick.Users)
+}
+
+func TestStandupNotes(t *testing.T) {
+	t.Parallel()
+	srv := server.NewServer()
+	mux := server.NewMux(srv)
+	testSrv := httptest.NewServer(mux)
+
+	c, err := client.New(testSrv.URL, "test1", "tester", io.Discard)
+	must.NoError(t, err)
+	must.NoError(t, c.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return c.State().Version == 1
+	})))
+
+	must.NoError(t, c.SetNotes(messages.StandupNotes{Today: strings.Repeat("x", server.MaxNoteLength+1)}))
+	must.NoError(t, c.SetNotes(messages.StandupNotes{
+		Yesterday: "reviewed PRs ",
+		Today:     "ship the release",
+		Blockers:  "waiting on CI",
+	}))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 2
+	})))
+	room, err := srv.GetRoom("test1")
+	must.NoError(t, err)
+	must.Eq(t, &messages.StandupNotes{
+		Yesterday: "reviewed PRs",
+		Today:     "ship the release",
+		Blockers:  "waiting on CI",
+	}, room.State().Rolls[0].Notes)
+
+	must.NoError(t, c.SetNotes(messages.StandupNotes{}))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 3
+	})))
+	must.Nil(t, room.State().Rolls[0].Notes)
+}
+
+func TestTeams(t *testing.T) {
+	t.Parallel()
+	srv := server.NewServer()
+	mux := server.NewMux(srv)
+	testSrv := httptest.NewServer(mux)
+
+	_, err := client.New(testSrv.URL, "test1", "dave", io.Discard,
+		client.WithRoomOptions(messages.RoomOptions{Teams: "loudest"}))
+	must.Error(t, err)
+
+	joins := []struct{ user, team string }{
+		{"dave", "red"},
+		{"alice", "blue"},
+		{"carol", ""},
+		{"bob", "red"},
+	}
+	for idx, join := range joins {
+		c, err := client.New(testSrv.URL, "test1", join.user, io.Discard,
+			client.WithTeam(join.team),
+			client.WithRoomOptions(messages.RoomOptions{Ordering: "alpha", Teams: "captain"}))
+		must.NoError(t, err)
+		must.NoError(t, c.Init())
+		must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+			return srv.GetRooms()["test1"].Version == idx+1
+		})))
+	}
+
+	room, err := srv.GetRoom("test1")
+	must.NoError(t, err)
+	state := room.State()
+	must.EqOp(t, "captain", state.Teams)
+	var order []string
+	for _, rr := range state.Rolls {
+		order = append(order, rr.Team+"/"+rr.User)
+	}
+	must.Eq(t, []string{"blue/alice", "red/bob", "red/dave", "/carol"}, order)
+}
+
+func TestPersistence(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	store, err := server.NewFileStore(dir)
+	must.NoError(t, err)
+	srv := server.NewServer(server.WithStore(store))
+	testSrv := httptest.NewServer(server.NewMux(srv))
+
+	c, err := client.New(testSrv.URL, "test1", "tester", io.Discard)
+	must.NoError(t, err)
+	must.NoError(t, c.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return c.State().Version == 1
+	})))
+	token := c.State().ResumeToken
+	must.NoError(t, c.ToggleDone())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 2
+	})))
+	result := srv.GetRooms()["test1"].Rolls["tester"].Result
+
+	// A new server picks up where the old one left off
+	store, err = server.NewFileStore(dir)
+	must.NoError(t, err)
+	restarted := server.NewServer(server.WithStore(store))
+	must.NoError(t, restarted.Restore())
+	room, err := restarted.GetRoom("test1")
+	must.NoError(t, err)
+	state := room.State()
+	must.EqOp(t, 2, state.Version)
+	must.SliceLen(t, 1, state.Rolls)
+	must.EqOp(t, messages.StatusDone, state.Rolls[0].Status)
+	must.True(t, state.Rolls[0].Disconnected)
+
+	restartedSrv := httptest.NewServer(server.NewMux(restarted))
+	back, err := client.New(restartedSrv.URL, "test1", "tester", io.Discard,
+		client.WithResumeToken(token))
+	must.NoError(t, err)
+	must.NoError(t, back.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return back.State().Version == 3
+	})))
+	must.EqOp(t, token, back.State().ResumeToken)
+	must.EqOp(t, result, back.State().Rolls[0].Result)
+	must.False(t, back.State().Rolls[0].Disconnected)
+}
+
+func TestRestoreWithoutGrace(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	store, err := server.NewFileStore(dir)
+	must.NoError(t, err)
+	srv := server.NewServer(server.WithStore(store), server.WithGracePeriod(0))
+	testSrv := httptest.NewServer(server.NewMux(srv))
+
+	c, err := client.New(testSrv.URL, "test1", "tester", io.Discard)
+	must.NoError(t, err)
+	must.NoError(t, c.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return c.State().Version == 1
+	})))
+
+	// Places are still held after a restart, so the room and its file stay
+	store, err = server.NewFileStore(dir)
+	must.NoError(t, err)
+	restarted := server.NewServer(server.WithStore(store), server.WithGracePeriod(0))
+	must.NoError(t, restarted.Restore())
+	room, err := restarted.GetRoom("test1")
+	must.NoError(t, err)
+	must.True(t, room.State().Rolls[0].Disconnected)
+	snaps, err := store.Load()
+	must.NoError(t, err)
+	must.SliceLen(t, 1, snaps)
+}
+
+func TestEventLog(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	srv := server.NewServer(server.WithEventLogs(dir))
+	testSrv := httptest.NewServer(server.NewMux(srv))
+
+	alice, err := client.New(testSrv.URL, "test1", "alice", io.Discard,
+		client.WithRoomOptions(messages.RoomOptions{TieBreak: "rolloff"}))
+	must.NoError(t, err)
+	must.NoError(t, alice.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return alice.State().Version == 1
+	})))
+	bob, err := client.New(testSrv.URL, "test1", "bob", io.Discard)
+	must.NoError(t, err)
+	must.NoError(t, bob.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 2
+	})))
+	must.NoError(t, alice.ToggleDone())
+	must.NoError(t, alice.SendChat("bob, you're up"))
+	must.NoError(t, alice.Pick(1, "note-taker"))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 5
+	})))
+	room, err := srv.GetRoom("test1")
+	must.NoError(t, err)
+	want := room.State()
+
+	must.NoError(t, bob.Close())
+	must.NoError(t, alice.Close())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		_, err := srv.GetRoom("test1")
+		return err != nil
+	})))
+
+	logs, err := filepath.Glob(filepath.Join(dir, "test1-*.jsonl"))
+	must.NoError(t, err)
+	must.SliceLen(t, 1, logs)
+	f, err := os.Open(logs[0])
+	must.NoError(t, err)
+	defer f.Close()
+	frames, err := server.Replay(f)
+	must.NoError(t, err)
+
+	// Created, two joins, done, chat, pick and two leaves
+	must.SliceLen(t, 8, frames)
+	must.SliceEmpty(t, frames[0].State.Rolls)
+	must.Eq(t, want, frames[5].State)
+	must.EqOp(t, "", frames[7].State.Host)
+}
+
+func TestEventLogSecrets(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	logDir := t.TempDir()
+	store, err := server.NewFileStore(dir)
+	must.NoError(t, err)
+	srv := server.NewServer(server.WithStore(store), server.WithEventLogs(logDir))
+	testSrv := httptest.NewServer(server.NewMux(srv))
+
+	c, err := client.New(testSrv.URL, "test1", "tester", io.Discard,
+		client.WithPassword("hunter2"))
+	must.NoError(t, err)
+	must.NoError(t, c.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return c.State().Version == 1
+	})))
+	token := c.State().ResumeToken
+
+	// Restoring starts a log with everyone's resume tokens in the room
+	store, err = server.NewFileStore(dir)
+	must.NoError(t, err)
+	restarted := server.NewServer(server.WithStore(store), server.WithEventLogs(logDir))
+	must.NoError(t, restarted.Restore())
+
+	logs, err := filepath.Glob(filepath.Join(logDir, "test1-*.jsonl"))
+	must.NoError(t, err)
+	must.SliceLen(t, 2, logs)
+	for _, log := range logs {
+		b, err := os.ReadFile(log)
+		must.NoError(t, err)
+		must.StrNotContains(t, string(b), "hunter2")
+		must.StrNotContains(t, string(b), token)
+		frames, err := server.Replay(bytes.NewReader(b))
+		must.NoError(t, err)
+		must.True(t, frames[0].State.Protected)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	t.Parallel()
+	srv := server.NewServer()
+	testSrv := httptest.NewServer(server.NewMux(srv))
+
+	closed := func() bool {
+		_, err := srv.GetRoom("test1")
+		return err != nil
+	}
+
+	alice, err := client.New(testSrv.URL, "test1", "alice", io.Discard)
+	must.NoError(t, err)
+	must.NoError(t, alice.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return alice.State().Version == 1
+	})))
+	bob, err := client.New(testSrv.URL, "test1", "bob", io.Discard)
+	must.NoError(t, err)
+	must.NoError(t, bob.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 2
+	})))
+	must.NoError(t, alice.ToggleDone())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 3
+	})))
+	must.NoError(t, bob.Close())
+	must.NoError(t, alice.Close())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(closed)))
+
+	// Only alice turns up the second time
+	alice, err = client.New(testSrv.URL, "test1", "alice", io.Discard)
+	must.NoError(t, err)
+	must.NoError(t, alice.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return alice.State().Version == 1
+	})))
+	must.NoError(t, alice.Close())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(closed)))
+
+	history, err := client.FetchHistory(testSrv.URL, "test1", "")
+	must.NoError(t, err)
+	must.EqOp(t, 2, history.Sessions)
+	must.SliceLen(t, 2, history.Users)
+	must.EqOp(t, "alice", history.Users[0].User)
+	must.SliceLen(t, 2, history.Users[0].Entries)
+	first := history.Users[0].Entries[0]
+	must.EqOp(t, 1, first.Position)
+	must.EqOp(t, 2, first.Of)
+	must.EqOp(t, messages.StatusDone, first.Status)
+	must.Positive(t, first.TimeToDone)
+	must.EqOp(t, "bob", history.Users[1].User)
+	must.SliceLen(t, 1, history.Users[1].Entries)
+	must.EqOp(t, 2, history.Users[1].Entries[0].Position)
+	must.EqOp(t, time.Duration(0), history.Users[1].Entries[0].TimeToDone)
+
+	empty, err := client.FetchHistory(testSrv.URL, "nothing-here", "")
+	must.NoError(t, err)
+	must.EqOp(t, 0, empty.Sessions)
+
+	spaced, err := client.FetchHistory(testSrv.URL, "stand up", "")
+	must.NoError(t, err)
+	must.EqOp(t, "stand up", spaced.Room)
+}
+
+func TestProtectedHistory(t *testing.T) {
+	t.Parallel()
+	srv := server.NewServer()
+	testSrv := httptest.NewServer(server.NewMux(srv))
+
+	alice, err := client.New(testSrv.URL, "test1", "alice", io.Discard,
+		client.WithPassword("hunter2"))
+	must.NoError(t, err)
+	must.NoError(t, alice.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return alice.State().Version == 1
+	})))
+	must.NoError(t, alice.Close())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		_, err := srv.GetRoom("test1")
+		return err != nil
+	})))
+
+	// Without the password the session isn't there
+	for _, password := range []string{"", "wrong"} {
+		history, err := client.FetchHistory(testSrv.URL, "test1", password)
+		must.NoError(t, err)
+		must.EqOp(t, 0, history.Sessions)
+		must.SliceEmpty(t, history.Users)
+	}
+	history, err := client.FetchHistory(testSrv.URL, "test1", "hunter2")
+	must.NoError(t, err)
+	must.EqOp(t, 1, history.Sessions)
+	must.EqOp(t, "alice", history.Users[0].User)
+}
+
+func TestRoomExpiry(t *testing.T) {
+	t.Parallel()
+
+	closedBy := func(t *testing.T, c *client.Client) messages.ErrorMessage {
+		for {
+			switch msg := c.ReadUpdate().(type) {
+			case messages.ErrorMessage:
+				return msg
+			case error:
+				t.Fatalf("connection ended without a reason: %v", msg)
+			}
+		}
+	}
+
+	t.Run("idle", func(t *testing.T) {
+		srv := server.NewServer(server.WithIdleTimeout(300 * time.Millisecond))
+		go srv.RunJanitor(t.Context())
+		testSrv := httptest.NewServer(server.NewMux(srv))
+
+		c, err := client.New(testSrv.URL, "test1", "tester", io.Discard)
+		must.NoError(t, err)
+		must.NoError(t, c.Init())
+		msg := closedBy(t, c)
+		must.EqOp(t, messages.ErrCodeRoomClosed, msg.Code)
+		must.StrContains(t, msg.Message, "idle")
+		_, err = srv.GetRoom("test1")
+		must.ErrorIs(t, err, server.ErrRoomNotExists)
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		srv := server.NewServer(server.WithRoomTTL(500 * time.Millisecond))
+		go srv.RunJanitor(t.Context())
+		testSrv := httptest.NewServer(server.NewMux(srv))
+
+		c, err := client.New(testSrv.URL, "test1", "tester", io.Discard)
+		must.NoError(t, err)
+		must.NoError(t, c.Init())
+		// Staying busy does not keep the room open past its limit
+		go func() {
+			for range 20 {
+				if c.ToggleDone() != nil {
+					return
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+		}()
+		msg := closedBy(t, c)
+		must.EqOp(t, messages.ErrCodeRoomClosed, msg.Code)
+		must.StrContains(t, msg.Message, "time limit")
+		_, err = srv.GetRoom("test1")
+		must.ErrorIs(t, err, server.ErrRoomNotExists)
+	})
+}
+
+func TestSchedules(t *testing.T) {
+	t.Parallel()
+
+	t.Run("recurrence", func(t *testing.T) {
+		rc, err := server.ParseRecurrence("weekdays 09:30")
+		must.NoError(t, err)
+		friday := time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC)
+		must.EqOp(t, time.Date(2026, 10, 19, 9, 30, 0, 0, time.UTC), rc.Next(friday))
+		early := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+		must.EqOp(t, time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC), rc.Next(early))
+
+		rc, err = server.ParseRecurrence("tue,thu 17:05")
+		must.NoError(t, err)
+		must.EqOp(t, time.Date(2026, 10, 20, 17, 5, 0, 0, time.UTC), rc.Next(friday))
+
+		for _, bad := range []string{"weekdays", "someday 09:30", "daily 25:00", "daily 9"} {
+			_, err = server.ParseRecurrence(bad)
+			must.ErrorIs(t, err, server.ErrInvalidRecurrence)
+		}
+	})
+
+	t.Run("bad file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "schedule.json")
+		must.NoError(t, os.WriteFile(path, []byte(`[{"room": "standup", "when": "daily 09:30", "duration": "15m", "dice": "lots"}]`), 0o600))
+		_, err := server.LoadSchedules(path)
+		must.ErrorContains(t, err, "invalid dice")
+	})
+
+	// endingSoon schedules a standup that started this minute and ends
+	// shortly.
+	endingSoon := func(t *testing.T) []server.Schedule {
+		now := time.Now()
+		started := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), 0, 0, time.Local)
+		duration := time.Since(started) + 1500*time.Millisecond
+		config := fmt.Sprintf(`[{
+			"room": "standup",
+			"when": "daily %s",
+			"duration": %q,
+			"dice": "2d6",
+			"order": "lowest",
+			"roster": ["alice", "bob"]
+		}]`, started.Format("15:04"), duration)
+		path := filepath.Join(t.TempDir(), "schedule.json")
+		must.NoError(t, os.WriteFile(path, []byte(config), 0o600))
+		schedules, err := server.LoadSchedules(path)
+		must.NoError(t, err)
+		return schedules
+	}
+	closed := func(srv *server.Server) func() bool {
+		return func() bool {
+			_, err := srv.GetRoom("standup")
+			return err != nil
+		}
+	}
+
+	t.Run("open and close", func(t *testing.T) {
+		schedules := endingSoon(t)
+		srv := server.NewServer()
+		go srv.RunSchedules(t.Context(), schedules)
+		testSrv := httptest.NewServer(server.NewMux(srv))
+
+		// The room is there before anyone joins
+		var (
+			room *server.Room
+			err  error
+		)
+		must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+			room, err = srv.GetRoom("standup")
+			return err == nil
+		})))
+		state := room.State()
+		must.EqOp(t, "2d6", state.Dice)
+		must.EqOp(t, "lowest", state.Ordering)
+		must.Eq(t, []string{"alice", "bob"}, state.Roster)
+		must.False(t, state.ClosesAt.IsZero())
+
+		c, err := client.New(testSrv.URL, "standup", "alice", io.Discard)
+		must.NoError(t, err)
+		must.NoError(t, c.Init())
+		must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+			return c.State().Version == 1
+		})))
+		must.Between(t, 2, c.State().Rolls[0].Result, 12)
+
+		// Leaving doesn't close it before its time
+		must.NoError(t, c.Close())
+		must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+			return srv.GetRooms()["standup"].Version == 2
+		})))
+		_, err = srv.GetRoom("standup")
+		must.NoError(t, err)
+
+		must.Wait(t, wait.InitialSuccess(
+			wait.BoolFunc(closed(srv)),
+			wait.Timeout(5*time.Second),
+		))
+	})
+
+	t.Run("restart", func(t *testing.T) {
+		schedules := endingSoon(t)
+		dir := t.TempDir()
+		store, err := server.NewFileStore(dir)
+		must.NoError(t, err)
+		srv := server.NewServer(server.WithStore(store))
+		ctx, cancel := context.WithCancel(t.Context())
+		go srv.RunSchedules(ctx, schedules)
+		var room *server.Room
+		must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+			room, err = srv.GetRoom("standup")
+			return err == nil
+		})))
+		closesAt := room.State().ClosesAt
+		must.False(t, closesAt.IsZero())
+		cancel()
+
+		// The restarted server keeps it open for the rest of its slot, then
+		// closes it
+		store, err = server.NewFileStore(dir)
+		must.NoError(t, err)
+		restarted := server.NewServer(server.WithStore(store))
+		must.NoError(t, restarted.Restore())
+		room, err = restarted.GetRoom("standup")
+		must.NoError(t, err)
+		must.True(t, closesAt.Equal(room.State().ClosesAt))
+		go restarted.RunSchedules(t.Context(), schedules)
+		must.Wait(t, wait.InitialSuccess(
+			wait.BoolFunc(closed(restarted)),
+			wait.Timeout(5*time.Second),
+		))
+	})
+
+	t.Run("existing room", func(t *testing.T) {
+		schedules := endingSoon(t)
+		srv := server.NewServer()
+		testSrv := httptest.NewServer(server.NewMux(srv))
+		c, err := client.New(testSrv.URL, "standup", "carol", io.Discard)
+		must.NoError(t, err)
+		must.NoError(t, c.Init())
+		must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+			return c.State().Version == 1
+		})))
+
+		// A room someone else opened keeps its own options and isn't closed
+		// at the end of the slot
+		go srv.RunSchedules(t.Context(), schedules)
+		time.Sleep(2 * time.Second)
+		room, err := srv.GetRoom("standup")
+		must.NoError(t, err)
+		state := room.State()
+		must.EqOp(t, "1d20", state.Dice)
+		must.SliceEmpty(t, state.Roster)
+		must.True(t, state.ClosesAt.IsZero())
+	})
+}
+
+func TestRoster(t *testing.T) {
+	t.Parallel()
+	srv := server.NewServer()
+	testSrv := httptest.NewServer(server.NewMux(srv))
+
+	alice, err := client.New(testSrv.URL, "test1", "alice", io.Discard,
+		client.WithRoomOptions(messages.RoomOptions{Roster: []string{"alice", "bob", " carol ", "bob"}}))
+	must.NoError(t, err)
+	must.NoError(t, alice.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return alice.State().Version == 1
+	})))
+	must.Eq(t, []string{"alice", "bob", "carol"}, alice.State().Roster)
+	must.SliceLen(t, 1, alice.State().Rolls)
+	must.SliceLen(t, 2, alice.State().NotJoined)
+	must.EqOp(t, "bob", alice.State().NotJoined[0].User)
+	must.EqOp(t, messages.StatusWaiting, alice.State().NotJoined[1].Status)
+
+	// The host marks carol absent before she turns up
+	must.NoError(t, alice.SetStatus("carol", messages.StatusAbsent))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 2
+	})))
+
+	bob, err := client.New(testSrv.URL, "test1", "bob", io.Discard)
+	must.NoError(t, err)
+	must.NoError(t, bob.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 3
+	})))
+	// Only the host can change it back
+	must.NoError(t, bob.SetStatus("carol", messages.StatusWaiting))
+	must.NoError(t, bob.SendChat("where's carol?"))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 4
+	})))
+
+	room, err := srv.GetRoom("test1")
+	must.NoError(t, err)
+	state := room.State()
+	must.SliceLen(t, 2, state.Rolls)
+	must.SliceLen(t, 1, state.NotJoined)
+	must.EqOp(t, "carol", state.NotJoined[0].User)
+	must.EqOp(t, messages.StatusAbsent, state.NotJoined[0].Status)
+
+	// Turning up anyway puts her in the turn order
+	carol, err := client.New(testSrv.URL, "test1", "carol", io.Discard)
+	must.NoError(t, err)
+	must.NoError(t, carol.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return carol.State().Version == 5
+	})))
+	must.SliceLen(t, 3, carol.State().Rolls)
+	must.SliceEmpty(t, carol.State().NotJoined)
+	for _, rr := range carol.State().Rolls {
+		must.EqOp(t, messages.StatusWaiting, rr.Status)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	store, err := server.NewFileStore(dir)
+	must.NoError(t, err)
+	srv := server.NewServer(
+		server.WithStore(store),
+		server.WithAdminToken("s3cret"),
+		server.WithTemplates(server.Template{
+			Name: "standup",
+			Options: messages.RoomOptions{
+				Dice:     "2d6",
+				Ordering: "lowest",
+				TieBreak: "alpha",
+				Timebox:  "2m",
+			},
+		}),
+	)
+	testSrv := httptest.NewServer(server.NewMux(srv))
+
+	put := func(name, body, token string) int {
+		req, err := http.NewRequest(http.MethodPut, testSrv.URL+"/templates/"+name, strings.NewReader(body))
+		must.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		must.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	must.EqOp(t, http.StatusUnauthorized, put("retro", `{"mode": "poker"}`, ""))
+	must.EqOp(t, http.StatusUnauthorized, put("retro", `{"mode": "poker"}`, "guess"))
+	must.EqOp(t, http.StatusNoContent, put("retro", `{"mode": "poker", "deck": "tshirt"}`, "s3cret"))
+	must.EqOp(t, http.StatusBadRequest, put("broken", `{"dice": "lots"}`, "s3cret"))
+	big := fmt.Sprintf(`{"roster": [%q]}`, strings.Repeat("x", server.MaxTemplateSize))
+	must.EqOp(t, http.StatusBadRequest, put("big", big, "s3cret"))
+
+	resp, err := http.Get(testSrv.URL + "/templates")
+	must.NoError(t, err)
+	var listed []server.Template
+	must.NoError(t, json.NewDecoder(resp.Body).Decode(&listed))
+	resp.Body.Close()
+	must.SliceLen(t, 2, listed)
+	must.EqOp(t, "retro", listed[0].Name)
+	must.EqOp(t, "tshirt", listed[0].Options.Deck)
+
+	// The creator's own options win over the template's
+	alice, err := client.New(testSrv.URL, "test1", "alice", io.Discard,
+		client.WithRoomOptions(messages.RoomOptions{Template: "standup", Ordering: "highest"}))
+	must.NoError(t, err)
+	must.NoError(t, alice.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return alice.State().Version == 1
+	})))
+	must.EqOp(t, "2d6", alice.State().Dice)
+	must.EqOp(t, "highest", alice.State().Ordering)
+	must.EqOp(t, "alpha", alice.State().TieBreak)
+	must.EqOp(t, 2*time.Minute, alice.State().Timebox)
+	must.False(t, alice.State().TurnStarted.IsZero())
+
+	// Once everyone is done no one's turn is being timed
+	must.NoError(t, alice.ToggleDone())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return alice.State().Version == 2
+	})))
+	must.True(t, alice.State().TurnStarted.IsZero())
+
+	_, err = client.New(testSrv.URL, "test2", "bob", io.Discard,
+		client.WithRoomOptions(messages.RoomOptions{Template: "nope"}))
+	must.ErrorIs(t, err, client.ErrRejected)
+
+	// Templates made through the API survive a restart
+	restarted := server.NewServer(server.WithStore(store))
+	must.NoError(t, restarted.Restore())
+	names := []string{}
+	for _, tmpl := range restarted.Templates() {
+		names = append(names, tmpl.Name)
+	}
+	must.Eq(t, []string{"retro"}, names)
+}
+
+func TestTemplateLimits(t *testing.T) {
+	t.Parallel()
+	put := func(url, token string) int {
+		req, err := http.NewRequest(http.MethodPut, url, strings.NewReader(`{"order": "shuffle"}`))
+		must.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		must.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Without an admin token no one can change templates
+	closed := httptest.NewServer(server.NewMux(server.NewServer()))
+	t.Cleanup(closed.Close)
+	must.EqOp(t, http.StatusForbidden, put(closed.URL+"/templates/retro", ""))
+
+	srv := server.NewServer(server.WithAdminToken("s3cret"))
+	testSrv := httptest.NewServer(server.NewMux(srv))
+	t.Cleanup(testSrv.Close)
+	for n := range server.MaxTemplates {
+		must.EqOp(t, http.StatusNoContent, put(fmt.Sprintf("%s/templates/t%d", testSrv.URL, n), "s3cret"))
+	}
+	must.EqOp(t, http.StatusConflict, put(testSrv.URL+"/templates/one-more", "s3cret"))
+	// Replacing one is still fine
+	must.EqOp(t, http.StatusNoContent, put(testSrv.URL+"/templates/t0", "s3cret"))
+	must.SliceLen(t, server.MaxTemplates, srv.Templates())
+}
+
+func TestAgenda(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	srv := server.NewServer(server.WithEventLogs(dir))
+	testSrv := httptest.NewServer(server.NewMux(srv))
+
+	// A phase that couldn't be rolled is refused up front
+	bad, err := messages.ParseAgenda("standup, retro:roll:1d0")
+	must.NoError(t, err)
+	_, err = client.New(testSrv.URL, "test1", "alice", io.Discard,
+		client.WithRoomOptions(messages.RoomOptions{Agenda: bad}))
+	must.ErrorIs(t, err, client.ErrRejected)
+	must.StrContains(t, err.Error(), "400")
+	must.MapEmpty(t, srv.GetRooms())
+
+	agenda, err := messages.ParseAgenda("standup:roll:1d20:2m, estimates:poker, retro::3d6")
+	must.NoError(t, err)
+	alice, err := client.New(testSrv.URL, "test1", "alice", io.Discard,
+		client.WithRoomOptions(messages.RoomOptions{Agenda: agenda}))
+	must.NoError(t, err)
+	must.NoError(t, alice.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return alice.State().Version == 1
+	})))
+	must.SliceLen(t, 3, alice.State().Agenda)
+	must.EqOp(t, 0, alice.State().Phase)
+	must.EqOp(t, "roll", alice.State().Mode)
+	must.EqOp(t, 2*time.Minute, alice.State().Timebox)
+
+	bob, err := client.New(testSrv.URL, "test1", "bob", io.Discard)
+	must.NoError(t, err)
+	must.NoError(t, bob.Init())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 2
+	})))
+	// Only the host moves the meeting on
+	must.NoError(t, bob.SetPhase(1))
+	must.NoError(t, bob.ToggleDone())
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 3
+	})))
+	must.EqOp(t, 0, srv.GetRooms()["test1"].Phase)
+
+	must.NoError(t, alice.SetPhase(1))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 4
+	})))
+	room, err := srv.GetRoom("test1")
+	must.NoError(t, err)
+	state := room.State()
+	must.EqOp(t, 1, state.Phase)
+	must.EqOp(t, "poker", state.Mode)
+	must.EqOp(t, "1d20", state.Dice)
+	must.EqOp(t, time.Duration(0), state.Timebox)
+	for _, rr := range state.Rolls {
+		must.EqOp(t, messages.StatusWaiting, rr.Status)
+	}
+
+	// Each phase starts from the room's own settings, not the last phase's
+	must.NoError(t, alice.SetPhase(2))
+	must.NoError(t, alice.SetPhase(3))
+	must.NoError(t, alice.SendChat("retro time"))
+	must.Wait(t, wait.InitialSuccess(wait.BoolFunc(func() bool {
+		return srv.GetRooms()["test1"].Version == 6
+	})))
+	want := room.State()
+	must.EqOp(t, 2, want.Phase)
+	must.EqOp(t, "roll", want.Mode)
+	must.EqOp(t, "3d6", want.Dice)
+	for _, rr := range want.Rolls {
+		must.Between(t, 3, rr.Result, 18)
+	}
+
+	// Replaying the log rerolls the same way
+	logs, err := filepath.Glob(filepath.Join(dir, "test1-*.jsonl"))
+	must.NoError(t, err)
+	must.SliceLen(t, 1, logs)
+	f, err := os.Open(logs[0])
+	must.NoError(t, err)
+	defer f.Close()
+	frames, err := server.Replay(f)
+	must.NoError(t, err)
+	must.SliceLen(t, 7, frames)
+	must.Eq(t, want, frames[6].State)
+}